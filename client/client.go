@@ -2,10 +2,12 @@ package client
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/fxamacker/cbor/v2"
@@ -42,6 +44,26 @@ func Client(cmd *cobra.Command, args []string) {
 		slog.Error("Error", slog.String("err", err.Error()))
 		return
 	}
+	registerToken, err := cmd.Flags().GetString("register-token")
+	if err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	tokenFile, err := cmd.Flags().GetString("token-file")
+	if err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	if token == "" {
+		token = loadToken(tokenFile)
+	}
+	if token == "" && registerToken != "" {
+		registerUrl := strings.TrimSuffix(reportUrl, "/") + "/register"
+		if token, err = enroll(registerUrl, registerToken, nodeId, tokenFile); err != nil {
+			slog.Error("Register", slog.String("err", err.Error()))
+			return
+		}
+	}
 	interval, err := cmd.Flags().GetInt("interval")
 	if err != nil {
 		slog.Error("Error", slog.String("err", err.Error()))
@@ -53,28 +75,113 @@ func Client(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	enableCollectors, err := cmd.Flags().GetStringSlice("collectors")
+	if err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	disableCollectors, err := cmd.Flags().GetStringSlice("disable-collectors")
+	if err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	if sensors {
+		enableCollectors = append(enableCollectors, "sensors")
+	}
+	var opts measure.Options
+	for flag, value := range map[string]*[]string{
+		"mount-include":     &opts.MountInclude,
+		"mount-exclude":     &opts.MountExclude,
+		"fstype-include":    &opts.FstypeInclude,
+		"fstype-exclude":    &opts.FstypeExclude,
+		"interface-include": &opts.InterfaceInclude,
+		"interface-exclude": &opts.InterfaceExclude,
+	} {
+		if *value, err = cmd.Flags().GetStringSlice(flag); err != nil {
+			slog.Error("Error", slog.String("err", err.Error()))
+			return
+		}
+	}
+	if opts.SysfsRoot, err = cmd.Flags().GetString("sysfs-root"); err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	if opts.BootFile, err = cmd.Flags().GetString("boot-file"); err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	measurer, err := measure.New(measure.SelectNames(enableCollectors, disableCollectors), opts)
+	if err != nil {
+		slog.Error("Init collectors", slog.String("err", err.Error()))
+		return
+	}
+
+	queueDir, err := cmd.Flags().GetString("queue-dir")
+	if err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	queueSize, err := cmd.Flags().GetInt("queue-size")
+	if err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	queue, err := newSampleQueue(queueDir, queueSize)
+	if err != nil {
+		slog.Error("Open report queue", slog.String("err", err.Error()))
+		return
+	}
+	r := &reporter{
+		nodeId:    nodeId,
+		reportUrl: reportUrl,
+		batchUrl:  strings.TrimSuffix(reportUrl, "/") + "/batch",
+		token:     token,
+		measurer:  measurer,
+		queue:     queue,
+	}
+
 	// init data for filling server status
-	report(nodeId, reportUrl, token, time.Second, sensors)
+	r.report(time.Second)
 
 	for {
-		err := report(nodeId, reportUrl, token, time.Duration(interval)*time.Second, sensors)
+		err := r.report(time.Duration(interval) * time.Second)
 		if err != nil {
 			slog.Error("Measure error", slog.String("err", err.Error()))
 		}
 	}
 }
 
-func report(nodeId, reportUrl, token string, interval time.Duration, useSensors bool) error {
-	samples, err := measure.Measure(interval, useSensors)
+const (
+	batchSize  = 100
+	minBackoff = 5 * time.Second
+	maxBackoff = 10 * time.Minute
+)
+
+// reporter measures samples into the queue and sends the queue in order,
+// backing off exponentially while the server is unreachable.
+type reporter struct {
+	nodeId    string
+	reportUrl string
+	batchUrl  string
+	token     string
+	measurer  *measure.Measurer
+
+	queue       *sampleQueue
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+func (r *reporter) report(interval time.Duration) error {
+	samples, err := r.measurer.Measure(interval)
 	if err != nil {
 		slog.Error("Measure error", slog.String("err", err.Error()))
 		return err
 	}
 
-	if nodeId == "" {
+	if r.nodeId == "" {
 		samples.NodeID = samples.Host.Hostname
 	} else {
-		samples.NodeID = nodeId
+		samples.NodeID = r.nodeId
 	}
 
 	bCbor, err := cbor.Marshal(samples)
@@ -84,15 +191,81 @@ func report(nodeId, reportUrl, token string, interval time.Duration, useSensors
 	}
 	slog.Debug("Measure", slog.Int("len", len(bCbor)), slog.Any("data", samples))
 
-	hReq, err := http.NewRequest(http.MethodPost, reportUrl, bytes.NewReader(bCbor))
+	if err = r.queue.Push(bCbor); err != nil {
+		slog.Error("Queue sample error", slog.String("err", err.Error()))
+		return err
+	}
+	if time.Now().Before(r.nextAttempt) {
+		slog.Debug("Report backoff", slog.Int("queued", r.queue.Len()), slog.Time("next", r.nextAttempt))
+		return nil
+	}
+
+	if err = r.flush(interval); err != nil {
+		if r.backoff == 0 {
+			r.backoff = minBackoff
+		} else {
+			r.backoff = min(r.backoff*2, maxBackoff)
+		}
+		r.nextAttempt = time.Now().Add(r.backoff)
+		slog.Warn("Report delayed", slog.Int("queued", r.queue.Len()), slog.Duration("backoff", r.backoff))
+		return err
+	}
+	r.backoff = 0
+	r.nextAttempt = time.Time{}
+	return nil
+}
+
+// flush sends every queued sample, a single one through the plain report
+// endpoint and a backlog through the batch endpoint in push order.
+func (r *reporter) flush(timeout time.Duration) error {
+	for r.queue.Len() > 0 {
+		items, err := r.queue.Peek(batchSize)
+		if err != nil {
+			slog.Error("Read queue error", slog.String("err", err.Error()))
+			return err
+		}
+		var url string
+		var body []byte
+		if len(items) == 1 {
+			url, body = r.reportUrl, items[0]
+		} else {
+			batch := make([]cbor.RawMessage, 0, len(items))
+			for _, item := range items {
+				batch = append(batch, item)
+			}
+			if body, err = cbor.Marshal(batch); err != nil {
+				slog.Error("Marshal error", slog.String("err", err.Error()))
+				return err
+			}
+			url = r.batchUrl
+		}
+
+		err = r.send(url, body, timeout)
+		if errors.Is(err, errRejected) {
+			// the server will never accept these samples, do not retry
+			r.queue.Remove(len(items))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.queue.Remove(len(items))
+	}
+	return nil
+}
+
+var errRejected = errors.New("report rejected")
+
+func (r *reporter) send(url string, body []byte, timeout time.Duration) error {
+	hReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
 		slog.Error("New report error", slog.String("err", err.Error()))
 		return err
 	}
 	hReq.Header.Set("Content-Type", "application/cbor")
-	hReq.Header.Set("Authorization", "Bearer "+token)
+	hReq.Header.Set("Authorization", "Bearer "+r.token)
 
-	hc := http.Client{Timeout: interval}
+	hc := http.Client{Timeout: timeout}
 	resp, err := hc.Do(hReq)
 	if err != nil {
 		slog.Error("Report send error", slog.String("err", err.Error()))
@@ -105,6 +278,9 @@ func report(nodeId, reportUrl, token string, interval time.Duration, useSensors
 		slog.Error("Report send error",
 			slog.Int("status", resp.StatusCode),
 			slog.String("body", string(body)))
+		if resp.StatusCode == http.StatusBadRequest {
+			return fmt.Errorf("%w: bad server response code %d", errRejected, resp.StatusCode)
+		}
 		return fmt.Errorf("bad server response code %d", resp.StatusCode)
 	}
 
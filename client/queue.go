@@ -0,0 +1,119 @@
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const queueFileExt = ".cbor"
+
+// sampleQueue keeps encoded samples waiting to be sent, oldest first. With
+// an empty dir it only lives in memory, otherwise every sample is a file so
+// the backlog survives agent restarts. When full the oldest sample is
+// dropped.
+type sampleQueue struct {
+	dir   string
+	limit int
+	seq   uint64
+	names []string
+	mem   [][]byte
+}
+
+func newSampleQueue(dir string, limit int) (*sampleQueue, error) {
+	q := &sampleQueue{dir: dir, limit: max(limit, 1)}
+	if dir == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), queueFileExt) {
+			q.names = append(q.names, e.Name())
+		}
+	}
+	sort.Strings(q.names)
+	for len(q.names) > q.limit {
+		q.dropOldest()
+	}
+	return q, nil
+}
+
+func (q *sampleQueue) Len() int {
+	if q.dir == "" {
+		return len(q.mem)
+	}
+	return len(q.names)
+}
+
+func (q *sampleQueue) Push(sample []byte) error {
+	for q.Len() >= q.limit {
+		q.dropOldest()
+	}
+	if q.dir == "" {
+		q.mem = append(q.mem, sample)
+		return nil
+	}
+	// zero padded names keep the directory listing in push order
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), q.seq%1000000, queueFileExt)
+	tmp := filepath.Join(q.dir, name+".tmp")
+	if err := os.WriteFile(tmp, sample, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, name)); err != nil {
+		return err
+	}
+	q.names = append(q.names, name)
+	return nil
+}
+
+// Peek returns up to n of the oldest samples.
+func (q *sampleQueue) Peek(n int) ([][]byte, error) {
+	n = min(n, q.Len())
+	if q.dir == "" {
+		return q.mem[:n], nil
+	}
+	result := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		b, err := os.ReadFile(filepath.Join(q.dir, q.names[i]))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, b)
+	}
+	return result, nil
+}
+
+// Remove drops the n oldest samples after they have been sent.
+func (q *sampleQueue) Remove(n int) {
+	for i := 0; i < n && q.Len() > 0; i++ {
+		q.removeFirst()
+	}
+}
+
+func (q *sampleQueue) dropOldest() {
+	slog.Warn("Report queue full, drop oldest sample")
+	q.removeFirst()
+}
+
+func (q *sampleQueue) removeFirst() {
+	if q.dir == "" {
+		q.mem[0] = nil
+		q.mem = q.mem[1:]
+		return
+	}
+	if err := os.Remove(filepath.Join(q.dir, q.names[0])); err != nil && !os.IsNotExist(err) {
+		slog.Error("Remove queued sample", slog.String("err", err.Error()))
+	}
+	q.names = q.names[1:]
+}
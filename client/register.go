@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+const registerRetry = 30 * time.Second
+
+var errRegisterRefused = errors.New("registration refused")
+
+// loadToken reads the node token kept by an earlier registration.
+func loadToken(tokenFile string) string {
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// loadSecret returns the enrollment secret kept next to the token file, a
+// new one is generated and kept on the first enrollment. The server only
+// hands the node token to the agent repeating it.
+func loadSecret(secretFile string) (string, error) {
+	if secret := loadToken(secretFile); secret != "" {
+		return secret, nil
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(b)
+	if err := os.WriteFile(secretFile, []byte(secret+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// enroll registers the agent with the registration token and waits until an
+// admin approves it, the issued node token is saved to tokenFile.
+func enroll(registerUrl, registerToken, nodeId, tokenFile string) (string, error) {
+	info, err := host.Info()
+	if err != nil {
+		return "", err
+	}
+	secretFile := tokenFile + ".secret"
+	secret, err := loadSecret(secretFile)
+	if err != nil {
+		return "", err
+	}
+	req := define.RegisterRequest{
+		NodeID:   nodeId,
+		Secret:   secret,
+		Hostname: info.Hostname,
+		Platform: info.Platform,
+		Version:  info.PlatformVersion,
+		Arch:     info.KernelArch,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		resp, err := sendRegister(registerUrl, registerToken, body)
+		switch {
+		case errors.Is(err, errRegisterRefused):
+			return "", err
+		case err != nil:
+			slog.Error("Register error", slog.String("err", err.Error()))
+		case resp.Status == define.PendingStatusApproved:
+			if err = os.WriteFile(tokenFile, []byte(resp.Token+"\n"), 0o600); err != nil {
+				slog.Error("Save token error", slog.String("file", tokenFile), slog.String("err", err.Error()))
+			} else {
+				os.Remove(secretFile)
+			}
+			slog.Info("Registration approved")
+			return resp.Token, nil
+		default:
+			slog.Info("Registration pending approval", slog.String("node", req.NodeID))
+		}
+		time.Sleep(registerRetry)
+	}
+}
+
+func sendRegister(url, token string, body []byte) (*define.RegisterResponse, error) {
+	hReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hReq.Header.Set("Content-Type", "application/json")
+	hReq.Header.Set("Authorization", "Bearer "+token)
+
+	hc := http.Client{Timeout: registerRetry}
+	resp, err := hc.Do(hReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict:
+		// retrying will not help until an admin steps in
+		return nil, fmt.Errorf("%w: %s", errRegisterRefused, respBody)
+	default:
+		return nil, fmt.Errorf("bad server response code %d: %s", resp.StatusCode, respBody)
+	}
+	var result define.RegisterResponse
+	if err = json.Unmarshal(respBody, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
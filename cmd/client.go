@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/spf13/cobra"
 	"github.com/zjyl1994/cloudstatus/client"
+	"github.com/zjyl1994/cloudstatus/service/measure"
+	"github.com/zjyl1994/cloudstatus/service/sensors"
 )
 
 // clientCmd represents the client command
@@ -18,6 +22,20 @@ func init() {
 	clientCmd.Flags().String("report", "", "Remote report url")
 	clientCmd.Flags().String("node", "", "Node ID")
 	clientCmd.Flags().String("token", "", "Node token")
+	clientCmd.Flags().String("register-token", "", "Registration token to enroll this agent when it has no node token")
+	clientCmd.Flags().String("token-file", "cloudstatus.token", "File keeping the node token issued on registration")
+	clientCmd.Flags().String("boot-file", "cloudstatus.boot", "File keeping the last boot time to flag reports after a reboot, empty to disable")
 	clientCmd.Flags().Int("interval", 60, "Report interval in seconds")
-	clientCmd.Flags().Bool("sensors", false, "Load tempature use lm-sensors")
+	clientCmd.Flags().Bool("sensors", false, "Report hardware sensors from sysfs, or lm-sensors when sysfs has none")
+	clientCmd.Flags().String("sysfs-root", sensors.DefaultSysfsRoot, "Root of the sysfs tree to read sensors from")
+	clientCmd.Flags().StringSlice("collectors", nil, "Extra collectors to enable, available: "+strings.Join(measure.Names(), ","))
+	clientCmd.Flags().StringSlice("disable-collectors", nil, "Default collectors to disable, defaults: "+strings.Join(measure.DefaultNames(), ","))
+	clientCmd.Flags().StringSlice("mount-include", nil, "Glob patterns of mount paths to report, empty for all")
+	clientCmd.Flags().StringSlice("mount-exclude", nil, "Glob patterns of mount paths to skip")
+	clientCmd.Flags().StringSlice("fstype-include", nil, "Glob patterns of filesystem types to report, empty for all")
+	clientCmd.Flags().StringSlice("fstype-exclude", measure.DefaultFstypeExclude, "Glob patterns of filesystem types to skip")
+	clientCmd.Flags().StringSlice("interface-include", nil, "Glob patterns of network interfaces to report, empty for all")
+	clientCmd.Flags().StringSlice("interface-exclude", measure.DefaultInterfaceExclude, "Glob patterns of network interfaces to skip")
+	clientCmd.Flags().String("queue-dir", "", "Directory buffering unsent samples, empty keeps them in memory")
+	clientCmd.Flags().Int("queue-size", 10000, "Max number of buffered samples")
 }
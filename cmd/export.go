@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/storage"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/record"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export raw history from the database as CSV or NDJSON",
+	Long: `Export raw history from the database as CSV or NDJSON.
+
+Records are read straight from the server database and streamed to the
+output ordered by node and time. Times are unix seconds, a date or a local
+date time such as "2006-01-02 15:04:05".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		driver, _ := flags.GetString("db-driver")
+		dsn, _ := flags.GetString("db")
+		nodes, _ := flags.GetStringSlice("nodes")
+		columns, _ := flags.GetStringSlice("columns")
+		format, _ := flags.GetString("format")
+		output, _ := flags.GetString("output")
+		startFlag, _ := flags.GetString("start")
+		endFlag, _ := flags.GetString("end")
+
+		end := time.Now()
+		var err error
+		if endFlag != "" {
+			if end, err = parseExportTime(endFlag); err != nil {
+				fmt.Fprintln(os.Stderr, "Error", err)
+				return
+			}
+		}
+		start := end.Add(-24 * time.Hour)
+		if startFlag != "" {
+			if start, err = parseExportTime(startFlag); err != nil {
+				fmt.Fprintln(os.Stderr, "Error", err)
+				return
+			}
+		}
+
+		// the database belongs to a server, read it as it is
+		vars.DB, err = storage.OpenExisting(driver, dsn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error", err)
+			return
+		}
+		// a database of an older version lacks the newer columns
+		if len(columns) == 0 {
+			available, err := record.ExportColumns()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error", err)
+				return
+			}
+			for _, name := range available {
+				if vars.DB.Migrator().HasColumn(&define.MeasureRecord{}, name) {
+					columns = append(columns, name)
+				}
+			}
+		}
+		if len(nodes) == 0 {
+			err = vars.DB.Model(&define.MeasureRecord{}).Distinct("node_id").Order("node_id").Pluck("node_id", &nodes).Error
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error", err)
+				return
+			}
+		}
+		exporter, err := record.NewExporter(record.ExportQuery{
+			Nodes:   nodes,
+			Start:   start.Unix(),
+			End:     end.Unix(),
+			Columns: columns,
+			Format:  format,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error", err)
+			return
+		}
+
+		out := os.Stdout
+		if output != "" && output != "-" {
+			if out, err = os.Create(output); err != nil {
+				fmt.Fprintln(os.Stderr, "Error", err)
+				return
+			}
+			defer out.Close()
+		}
+		if err = exporter.Write(out); err != nil {
+			fmt.Fprintln(os.Stderr, "Error", err)
+		}
+	},
+}
+
+// parseExportTime reads unix seconds, a date or a date time in local time.
+func parseExportTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().String("db", "cloudstatus.db", "Database file for sqlite, DSN for other drivers")
+	exportCmd.Flags().String("db-driver", storage.DefaultDriver, "Database driver")
+	exportCmd.Flags().StringSlice("nodes", nil, "Node ids to export, all when empty")
+	exportCmd.Flags().StringSlice("columns", nil, "Columns to export, all when empty")
+	exportCmd.Flags().String("format", record.ExportCSV, "Output format: csv or ndjson")
+	exportCmd.Flags().String("start", "", "Start time, 24 hours before end when empty")
+	exportCmd.Flags().String("end", "", "End time, now when empty")
+	exportCmd.Flags().StringP("output", "o", "", "Output file, stdout when empty")
+}
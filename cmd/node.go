@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+// nodeCmd represents the node command
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Manage agent registrations through the admin API",
+}
+
+// nodePendingCmd represents the node pending command
+var nodePendingCmd = &cobra.Command{
+	Use:   "pending",
+	Short: "List agents waiting for approval",
+	Run: func(cmd *cobra.Command, args []string) {
+		var nodes []define.PendingNode
+		if err := adminRequest(cmd, http.MethodGet, "/pending", nil, &nodes); err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		for _, n := range nodes {
+			fmt.Printf("%s\t%s\t%s\t%s %s %s\t%s\t%s\n", n.NodeID, n.Status, n.Hostname, n.Platform, n.Version, n.Arch,
+				n.Address, time.Unix(n.LastSeen, 0).Format(time.DateTime))
+		}
+	},
+}
+
+// nodeApproveCmd represents the node approve command
+var nodeApproveCmd = &cobra.Command{
+	Use:   "approve <node-id>",
+	Short: "Approve a pending agent and issue its node token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var node define.ServerNode
+		node.Label, _ = cmd.Flags().GetString("label")
+		node.Location, _ = cmd.Flags().GetString("location")
+		path := "/pending/" + url.PathEscape(args[0]) + "/approve"
+		if err := adminRequest(cmd, http.MethodPost, path, node, &node); err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		fmt.Printf("%s: approved, the agent receives its token on the next registration attempt\n", node.ID)
+	},
+}
+
+// nodeRejectCmd represents the node reject command
+var nodeRejectCmd = &cobra.Command{
+	Use:   "reject <node-id>",
+	Short: "Reject a pending agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := "/pending/" + url.PathEscape(args[0]) + "/reject"
+		if err := adminRequest(cmd, http.MethodPost, path, nil, nil); err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		fmt.Printf("%s: rejected\n", args[0])
+	},
+}
+
+// adminRequest calls the admin API of the server named by the command flags.
+func adminRequest(cmd *cobra.Command, method, path string, body, result any) error {
+	serverUrl, _ := cmd.Flags().GetString("server")
+	token, _ := cmd.Flags().GetString("admin-token")
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(serverUrl, "/")+"/api/admin"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	hc := http.Client{Timeout: 30 * time.Second}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("server response %d: %s", resp.StatusCode, respBody)
+	}
+	if result == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func init() {
+	rootCmd.AddCommand(nodeCmd)
+	nodeCmd.AddCommand(nodePendingCmd, nodeApproveCmd, nodeRejectCmd)
+	nodeCmd.PersistentFlags().String("server", "http://127.0.0.1:10567", "Server base url")
+	nodeCmd.PersistentFlags().String("admin-token", "", "Admin token of the server")
+	nodeApproveCmd.Flags().String("label", "", "Node label, defaults to the reported hostname")
+	nodeApproveCmd.Flags().String("location", "", "Node location")
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/server"
+	"github.com/zjyl1994/cloudstatus/service/notify"
+)
+
+// notifyCmd represents the notify command
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Alert notification tools",
+}
+
+// notifyTestCmd represents the notify test command
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a sample alert through a configured channel",
+	// a failed or unknown channel exits non-zero, so scripts can check it
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configFile, _ := cmd.Flags().GetString("config")
+		channel, _ := cmd.Flags().GetString("channel")
+
+		cfg, err := server.LoadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		if channel != "" && !slices.ContainsFunc(cfg.Notifiers, func(n define.NotifierConfig) bool {
+			return n.Name == channel
+		}) {
+			return fmt.Errorf("unknown channel %q", channel)
+		}
+		if len(cfg.Notifiers) == 0 {
+			return errors.New("no notifier configured")
+		}
+		node := define.ServerNode{ID: "test-node", Label: "Test Node"}
+		if len(cfg.Nodes) > 0 {
+			node = cfg.Nodes[0]
+		}
+
+		var failed int
+		for _, n := range cfg.Notifiers {
+			if channel != "" && n.Name != channel {
+				continue
+			}
+			ch, err := notify.New(n)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err = ch.Send(ctx, notify.SampleEvent(node))
+			cancel()
+			if err != nil {
+				fmt.Printf("%s: failed, %v\n", n.Name, err)
+				failed++
+			} else {
+				fmt.Printf("%s: sent\n", n.Name)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d channel(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(notifyCmd)
+	notifyCmd.AddCommand(notifyTestCmd)
+	notifyTestCmd.Flags().String("config", "config.json", "Config file")
+	notifyTestCmd.Flags().String("channel", "", "Notifier name, empty for all channels")
+}
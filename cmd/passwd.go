@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwdCmd represents the passwd command
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Hash a dashboard user password for the auth config",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			fmt.Println("Error", err)
+			return
+		}
+		password = strings.TrimRight(password, "\r\n")
+		if password == "" {
+			fmt.Println("Error", "empty password")
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		fmt.Println(string(hash))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(passwdCmd)
+}
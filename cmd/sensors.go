@@ -2,28 +2,61 @@ package cmd
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/spf13/cobra"
+	"github.com/zjyl1994/cloudstatus/infra/define"
 	"github.com/zjyl1994/cloudstatus/service/sensors"
 )
 
 // sensorsCmd represents the sensors command
 var sensorsCmd = &cobra.Command{
 	Use:   "sensors",
-	Short: "Check lm-sensors output",
+	Short: "Check hardware sensor readings",
 	Run: func(cmd *cobra.Command, args []string) {
-		result, err := sensors.Get()
+		sysfsRoot, err := cmd.Flags().GetString("sysfs-root")
+		if err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		lmSensors, err := cmd.Flags().GetBool("lm-sensors")
+		if err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		var result []define.SensorStat
+		if lmSensors {
+			result, err = sensors.ReadLmSensors()
+		} else {
+			result, err = sensors.Get(sysfsRoot)
+		}
 		if err != nil {
 			fmt.Println("Error", err)
 			return
 		}
 		fmt.Println("Sensors Output:")
-		for sensorName, temp := range result {
-			fmt.Printf("%s: %.2f\n", sensorName, temp)
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Type < result[j].Type
+		})
+		for _, r := range result {
+			fmt.Printf("[%s] %s: %.2f %s", r.Type, r.Name, r.Value, r.Unit)
+			if r.Min > 0 {
+				fmt.Printf(" (min %.2f)", r.Min)
+			}
+			if r.Max > 0 {
+				fmt.Printf(" (max %.2f)", r.Max)
+			}
+			if r.Crit > 0 {
+				fmt.Printf(" (crit %.2f)", r.Crit)
+			}
+			fmt.Println()
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(sensorsCmd)
+
+	sensorsCmd.Flags().String("sysfs-root", sensors.DefaultSysfsRoot, "Root of the sysfs tree to read hwmon and thermal devices from")
+	sensorsCmd.Flags().Bool("lm-sensors", false, "Only read lm-sensors output")
 }
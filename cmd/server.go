@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"strings"
+	"time"
+
 	"github.com/spf13/cobra"
+	"github.com/zjyl1994/cloudstatus/infra/storage"
 	"github.com/zjyl1994/cloudstatus/server"
 )
 
@@ -16,6 +20,10 @@ func init() {
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.Flags().String("config", "config.json", "Config file")
 	serverCmd.Flags().String("listen", "127.0.0.1:10567", "Server listen address")
-	serverCmd.Flags().String("db", "cloudstatus.db", "Database file")
+	serverCmd.Flags().String("db", "cloudstatus.db", "Database file for sqlite, DSN for other drivers")
+	serverCmd.Flags().String("db-driver", storage.DefaultDriver, "Database driver: "+strings.Join(storage.Names(), ","))
 	serverCmd.Flags().Int("alive", 180, "Alive time for nodes")
+	serverCmd.Flags().Int("write-queue", 10000, "Records queued in memory before reports are refused")
+	serverCmd.Flags().Int("write-batch", 500, "Records written per database transaction")
+	serverCmd.Flags().Duration("write-interval", time.Second, "Longest time a record waits to be written")
 }
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/storage"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/record"
+)
+
+// checkNodeID is the node written by the storage check, its rows are
+// removed afterwards.
+const checkNodeID = "cloudstatus-storage-check"
+
+// storageCmd represents the storage command
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Database backend tools",
+}
+
+var storageCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Run the record store against a database to check the driver works",
+	Long: `Run the record store against a database to check the driver works.
+
+Without --dsn a temporary sqlite file is used. The check writes records of a
+scratch node, reads them back through traffic, history, rollup and cleanup,
+and deletes them again. Cleanup drops records of nodes missing from its
+scratch config, so only empty databases are accepted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		driver, err := cmd.Flags().GetString("driver")
+		if err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		dsn, err := cmd.Flags().GetString("dsn")
+		if err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		if dsn == "" {
+			if driver != storage.DefaultDriver {
+				fmt.Println("Error", "--dsn is required for driver "+driver)
+				return
+			}
+			dir, err := os.MkdirTemp("", "cloudstatus-check")
+			if err != nil {
+				fmt.Println("Error", err)
+				return
+			}
+			defer os.RemoveAll(dir)
+			dsn = filepath.Join(dir, "check.db")
+		}
+
+		vars.DB, err = storage.Open(driver, dsn)
+		if err != nil {
+			fmt.Println("Error", err)
+			return
+		}
+		for _, model := range storage.Models {
+			var count int64
+			if err = vars.DB.Model(model).Count(&count).Error; err != nil {
+				fmt.Println("Error", err)
+				return
+			}
+			if count > 0 {
+				fmt.Println("Error", "database is not empty, point the check at a scratch database")
+				return
+			}
+		}
+		err = runStorageCheck()
+		if cleanErr := cleanStorageCheck(); cleanErr != nil {
+			fmt.Println("Error", "clean up:", cleanErr)
+		}
+		if err != nil {
+			fmt.Println("FAIL", err)
+			return
+		}
+		fmt.Println("OK", driver)
+	},
+}
+
+func runStorageCheck() error {
+	cfg := *vars.Config.Load()
+	cfg.Nodes = append(cfg.Nodes, define.ServerNode{ID: checkNodeID})
+	cfg.Retention = define.RetentionConfig{Raw: 1}
+	cfg.Location = time.UTC
+	vars.Config.Store(&cfg)
+
+	// two recent records and one past the raw retention
+	now := time.Now().Unix()
+	times := []int64{now - 3*86400, now - 120, now - 60}
+	var send, recv uint64
+	for i, ts := range times {
+		stat := define.StatExchangeFormat{NodeID: checkNodeID, ReportTime: ts}
+		stat.Percent.CPU = float64(10 * (i + 1))
+		stat.Network.Send = uint64(1000 * (i + 1))
+		stat.Network.Recv = uint64(2000 * (i + 1))
+		stat.Disk.Mounts = []define.MountStat{{Path: "/", Percent: 50}}
+		if err := record.WriteRecord(&stat); err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+		// only the cycle holding now is reported by GetNetTraffic
+		if start, end := record.BillingCycle(0, time.Unix(ts, 0), time.UTC); start.Unix() <= now && end.Unix() > now {
+			send += stat.Network.Send
+			recv += stat.Network.Recv
+		}
+	}
+	fmt.Println("ok   write records")
+
+	traffic, err := record.GetNetTraffic()
+	if err != nil {
+		return fmt.Errorf("net traffic: %w", err)
+	}
+	var found bool
+	for _, t := range traffic {
+		if t.NodeId == checkNodeID {
+			found = true
+			if t.NetSend != send || t.NetRecv != recv {
+				return fmt.Errorf("net traffic: got %d/%d, want %d/%d", t.NetSend, t.NetRecv, send, recv)
+			}
+		}
+	}
+	if !found && (send > 0 || recv > 0) {
+		return fmt.Errorf("net traffic: node missing")
+	}
+	fmt.Println("ok   traffic ledger")
+
+	records, err := record.LoadRecord(checkNodeID, now-600, now)
+	if err != nil {
+		return fmt.Errorf("load record: %w", err)
+	}
+	if len(records) != 2 || records[0].Timestamp != times[2] || records[0].CPU != 30 {
+		return fmt.Errorf("load record: got %d records, want 2 newest first", len(records))
+	}
+	fmt.Println("ok   load records")
+
+	if err = record.RunRollup(); err != nil {
+		return fmt.Errorf("rollup: %w", err)
+	}
+	// run twice, the second run upserts over the first
+	if err = record.RunRollup(); err != nil {
+		return fmt.Errorf("rollup again: %w", err)
+	}
+	rollups, err := record.LoadRollup(checkNodeID, record.Resolutions[0], record.AggMax, times[0]-86400, now)
+	if err != nil {
+		return fmt.Errorf("load rollup: %w", err)
+	}
+	if len(rollups) == 0 {
+		return fmt.Errorf("load rollup: no rollups built")
+	}
+	fmt.Println("ok   rollups")
+
+	if err = record.CleanRecord(); err != nil {
+		return fmt.Errorf("clean record: %w", err)
+	}
+	records, err = record.LoadRecord(checkNodeID, 0, now)
+	if err != nil {
+		return fmt.Errorf("load record: %w", err)
+	}
+	if len(records) != 2 {
+		return fmt.Errorf("clean record: %d records left, want 2", len(records))
+	}
+	fmt.Println("ok   clean records")
+	return nil
+}
+
+// cleanStorageCheck removes every row of the check node.
+func cleanStorageCheck() error {
+	for _, model := range storage.Models {
+		if err := vars.DB.Where("node_id = ?", checkNodeID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(storageCmd)
+	storageCmd.AddCommand(storageCheckCmd)
+	storageCheckCmd.Flags().String("driver", storage.DefaultDriver, "Database driver")
+	storageCheckCmd.Flags().String("dsn", "", "Database DSN, a temporary sqlite file when empty")
+}
@@ -0,0 +1,30 @@
+package define
+
+import "time"
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertRule describes a threshold rule such as "cpu > 90 for 5m".
+type AlertRule struct {
+	Name      string   `json:"name"`
+	Metric    string   `json:"metric"`
+	Sensor    string   `json:"sensor"`
+	Mount     string   `json:"mount"`
+	Operator  string   `json:"op"`
+	Threshold float64  `json:"threshold"`
+	For       string   `json:"for"`
+	Nodes     []string `json:"nodes"`
+	Channels  []string `json:"channels"`
+}
+
+type AlertEvent struct {
+	Rule  AlertRule  `json:"rule"`
+	Node  ServerNode `json:"node"`
+	State string     `json:"state"`
+	Value float64    `json:"value"`
+	Since time.Time  `json:"since"`
+	Time  time.Time  `json:"time"`
+}
@@ -1,9 +1,70 @@
 package define
 
+import "time"
+
 type ServerConfig struct {
-	Token string       `json:"token"`
-	Title string       `json:"title"`
-	Nodes []ServerNode `json:"nodes"`
+	Token string `json:"token"`
+	// AdminToken guards the admin API, plain text or "sha256:<hex>". The
+	// admin API is disabled when empty.
+	AdminToken string `json:"admin_token,omitempty"`
+	// RegistrationToken lets unknown agents ask to be added as nodes, plain
+	// text or "sha256:<hex>". Registration is disabled when empty.
+	RegistrationToken string           `json:"registration_token,omitempty"`
+	Title             string           `json:"title"`
+	Nodes             []ServerNode     `json:"nodes"`
+	Alerts            []AlertRule      `json:"alerts"`
+	Notifiers         []NotifierConfig `json:"notifiers"`
+	Retention         RetentionConfig  `json:"retention"`
+	// Timezone is the IANA zone used for billing cycles, empty for local time.
+	Timezone string `json:"timezone"`
+	// Location is the loaded Timezone, set when the config is applied.
+	Location *time.Location `json:"-"`
+	// CorsOrigins lists the origins allowed to call the API from browsers,
+	// empty allows every origin.
+	CorsOrigins []string   `json:"cors_origins"`
+	Auth        AuthConfig `json:"auth"`
+	// Database selects the storage backend, the server flags override it.
+	// It is only read at startup.
+	Database DatabaseConfig `json:"database"`
+}
+
+// DatabaseConfig is a database driver (sqlite, postgres or mysql) and its
+// DSN, a file path for sqlite.
+type DatabaseConfig struct {
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
+}
+
+// AuthConfig configures the dashboard login. Without users and tokens the
+// dashboard is public.
+type AuthConfig struct {
+	// Private requires a login for the whole dashboard, otherwise only
+	// private nodes are hidden from anonymous viewers.
+	Private bool       `json:"private"`
+	Users   []AuthUser `json:"users"`
+	// Tokens are bearer tokens for the read APIs, plain text or
+	// "sha256:<hex>".
+	Tokens []string `json:"tokens"`
+	// SessionTTL is how long a login lasts, like "168h", default 7 days.
+	SessionTTL string `json:"session_ttl"`
+	// SessionSecret signs session cookies, a random one is used when empty
+	// so sessions end with the server.
+	SessionSecret string `json:"session_secret,omitempty"`
+}
+
+// AuthUser is a dashboard user, Password is a bcrypt hash.
+type AuthUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RetentionConfig holds how many days each history level is kept,
+// zero keeps it forever.
+type RetentionConfig struct {
+	Raw      int `json:"raw"`
+	Rollup5m int `json:"rollup_5m"`
+	Rollup1h int `json:"rollup_1h"`
+	Rollup1d int `json:"rollup_1d"`
 }
 
 type ServerNode struct {
@@ -11,4 +72,24 @@ type ServerNode struct {
 	Label    string `json:"label"`
 	Location string `json:"location"`
 	ResetDay int    `json:"reset_day"`
+	// Quota is the traffic limit of a billing cycle in bytes, zero for
+	// unlimited. QuotaMode selects the counted traffic: out, in, sum or max.
+	Quota     uint64 `json:"quota"`
+	QuotaMode string `json:"quota_mode"`
+	// Mounts selects the mount paths shown for this node, empty shows the
+	// fullest mounts first.
+	Mounts []string `json:"mounts"`
+	// Private hides the node from viewers who are not logged in.
+	Private bool `json:"private"`
+	// Tags group nodes, such as by role or provider, for comparisons.
+	Tags []string `json:"tags"`
+	// Token is the report token of this node, either plain text or
+	// "sha256:<hex>". Empty means the global token is used.
+	Token string `json:"token,omitempty"`
+}
+
+// Public returns a copy of the node without secrets, safe for public APIs.
+func (n ServerNode) Public() ServerNode {
+	n.Token = ""
+	return n
 }
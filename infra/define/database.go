@@ -1,10 +1,29 @@
 package define
 
+// MeasureRecord is one report of a node, a node has at most one record
+// per second so replayed reports are stored once.
 type MeasureRecord struct {
-	ID          int64  `gorm:"primaryKey;autoIncrement;not null"`
-	NodeID      string `gorm:"index:ix_mr_node_time"`
-	Timestamp   int64  `gorm:"index:ix_mr_node_time"`
+	ID        int64  `gorm:"primaryKey;autoIncrement;not null"`
+	NodeID    string `gorm:"size:128;uniqueIndex:ux_mr_node_time"`
+	Timestamp int64  `gorm:"uniqueIndex:ux_mr_node_time"`
+	MeasureValues
+	NetSend uint64
+	NetRecv uint64
+}
+
+// MeasureValues holds the metrics shared by raw records and rollups.
+// Numeric fields and JSON encoded map[string]float64 strings are
+// aggregated field by field when rolling up.
+type MeasureValues struct {
 	CPU         float64
+	CPUUser     float64
+	CPUSystem   float64
+	CPUNice     float64
+	CPUIowait   float64
+	CPUIrq      float64
+	CPUSoftirq  float64
+	CPUSteal    float64
+	CPUCores    string
 	Memory      float64
 	Swap        float64
 	Disk        float64
@@ -15,9 +34,44 @@ type MeasureRecord struct {
 	DiskWx      uint64
 	NetRx       uint64
 	NetTx       uint64
-	NetSend     uint64 `gorm:"index:ix_mr_node_time"`
-	NetRecv     uint64 `gorm:"index:ix_mr_node_time"`
 	Temperature string
+	Mounts      string
+	Interfaces  string
+	Sensors     string
+}
+
+// MeasureRollup is one aggregate (avg, min or max) of the records of a
+// node inside a bucket of Resolution seconds starting at Timestamp.
+type MeasureRollup struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement;not null"`
+	NodeID     string `gorm:"size:128;uniqueIndex:ux_ru_node_time"`
+	Resolution int64  `gorm:"uniqueIndex:ux_ru_node_time"`
+	Timestamp  int64  `gorm:"uniqueIndex:ux_ru_node_time"`
+	Agg        string `gorm:"size:8;uniqueIndex:ux_ru_node_time"`
+	Samples    int64
+	MeasureValues
+}
+
+// TrafficLedger accumulates the traffic of a node in one billing cycle
+// [CycleStart, CycleEnd).
+type TrafficLedger struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement;not null" json:"-"`
+	NodeID     string `gorm:"size:128;uniqueIndex:ux_tl_node_cycle" json:"node_id"`
+	CycleStart int64  `gorm:"uniqueIndex:ux_tl_node_cycle" json:"cycle_start"`
+	CycleEnd   int64  `json:"cycle_end"`
+	NetSend    uint64 `json:"net_send"`
+	NetRecv    uint64 `json:"net_recv"`
+}
+
+const NodeEventReboot = "reboot"
+
+// NodeEvent is something that happened on a node, such as a reboot.
+type NodeEvent struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement;not null" json:"-"`
+	NodeID    string `gorm:"size:128;uniqueIndex:ux_ne_node_time" json:"node_id"`
+	Timestamp int64  `gorm:"uniqueIndex:ux_ne_node_time" json:"time"`
+	Type      string `gorm:"size:32;uniqueIndex:ux_ne_node_time" json:"type"`
+	Message   string `json:"message"`
 }
 
 type TrafficCalcResult struct {
@@ -25,3 +79,26 @@ type TrafficCalcResult struct {
 	NetSend uint64 `gorm:"column:net_send"`
 	NetRecv uint64 `gorm:"column:net_recv"`
 }
+
+const (
+	PendingStatusPending  = "pending"
+	PendingStatusApproved = "approved"
+	PendingStatusRejected = "rejected"
+)
+
+// PendingNode is an agent that asked to register with the registration
+// token. Once approved, only the agent holding the secret of SecretDigest
+// is issued a node token, until the node first reports.
+type PendingNode struct {
+	ID           int64  `gorm:"primaryKey;autoIncrement;not null" json:"-"`
+	NodeID       string `gorm:"size:128;uniqueIndex:ux_pn_node" json:"node_id"`
+	Hostname     string `json:"hostname"`
+	Platform     string `json:"platform"`
+	Version      string `json:"version"`
+	Arch         string `json:"arch"`
+	Address      string `json:"address"`
+	Status       string `json:"status"`
+	SecretDigest string `json:"-"`
+	FirstSeen    int64  `json:"first_seen"`
+	LastSeen     int64  `json:"last_seen"`
+}
@@ -0,0 +1,18 @@
+package define
+
+// NotifierConfig describes one notification channel. Endpoint is the
+// webhook url, the SMTP host:port or the bot API base url.
+type NotifierConfig struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Endpoint string            `json:"endpoint"`
+	Template string            `json:"template"`
+	Retries  int               `json:"retries"`
+	Headers  map[string]string `json:"headers"`
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	From     string            `json:"from"`
+	To       []string          `json:"to"`
+	Token    string            `json:"token"`
+	ChatID   string            `json:"chat_id"`
+}
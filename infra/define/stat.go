@@ -7,6 +7,7 @@ type StatExchangeFormat struct {
 		Swap float64 `json:"swap"`
 		Disk float64 `json:"disk"`
 	} `json:"percent"`
+	CPU  CPUStat `json:"cpu"`
 	Load struct {
 		Load1  float64 `json:"load1"`
 		Load5  float64 `json:"load5"`
@@ -16,14 +17,17 @@ type StatExchangeFormat struct {
 	Swap   UsageStat `json:"swap"`
 	Disk   struct {
 		UsageStat
-		Rx uint64 `json:"rx"`
-		Wx uint64 `json:"wx"`
+		Rx     uint64      `json:"rx"`
+		Wx     uint64      `json:"wx"`
+		Mounts []MountStat `json:"mounts"`
 	} `json:"disk"`
 	Network struct {
-		Rx   uint64 `json:"rx"`
-		Tx   uint64 `json:"tx"`
-		Send uint64 `json:"sb"`
-		Recv uint64 `json:"rb"`
+		Rx         uint64          `json:"rx"`
+		Tx         uint64          `json:"tx"`
+		Send       uint64          `json:"sb"`
+		Recv       uint64          `json:"rb"`
+		Quota      *QuotaUsage     `json:"quota,omitempty"`
+		Interfaces []InterfaceStat `json:"interfaces"`
 	} `json:"network"`
 	Host struct {
 		Uptime   uint64 `json:"uptime"`
@@ -31,11 +35,13 @@ type StatExchangeFormat struct {
 		Platform string `json:"platform"`
 		Version  string `json:"version"`
 		Arch     string `json:"arch"`
+		Rebooted bool   `json:"rebooted"`
 	}
 	NodeID      string             `json:"node_id"`
 	Interval    uint64             `json:"interval"`
 	ReportTime  int64              `json:"report"`
 	Temperature map[string]float64 `json:"temperature"`
+	Sensors     []SensorStat       `json:"sensors"`
 	Metadata    ServerNode         `json:"metadata"`
 	NodeAlive   bool               `json:"node_alive"`
 }
@@ -45,3 +51,108 @@ type UsageStat struct {
 	Used  uint64 `json:"used"`
 	Free  uint64 `json:"free"`
 }
+
+// QuotaUsage is the traffic quota state of a node in its current cycle.
+type QuotaUsage struct {
+	Limit            uint64  `json:"limit"`
+	Used             uint64  `json:"used"`
+	Remaining        uint64  `json:"remaining"`
+	Percent          float64 `json:"percent"`
+	Projected        uint64  `json:"projected"`
+	ProjectedPercent float64 `json:"projected_percent"`
+	CycleStart       int64   `json:"cycle_start"`
+	CycleEnd         int64   `json:"cycle_end"`
+}
+
+// CPUStat is the share of CPU time by mode and the usage of every core
+// during the interval, all in percent.
+type CPUStat struct {
+	User    float64   `json:"user"`
+	System  float64   `json:"system"`
+	Nice    float64   `json:"nice"`
+	Iowait  float64   `json:"iowait"`
+	Irq     float64   `json:"irq"`
+	Softirq float64   `json:"softirq"`
+	Steal   float64   `json:"steal"`
+	Idle    float64   `json:"idle"`
+	Cores   []float64 `json:"cores"`
+}
+
+// MountStat is the usage of one mounted filesystem.
+type MountStat struct {
+	Path          string  `json:"path"`
+	Device        string  `json:"device"`
+	Fstype        string  `json:"fstype"`
+	Total         uint64  `json:"total"`
+	Used          uint64  `json:"used"`
+	Free          uint64  `json:"free"`
+	Percent       float64 `json:"percent"`
+	InodesTotal   uint64  `json:"inodes_total"`
+	InodesUsed    uint64  `json:"inodes_used"`
+	InodesPercent float64 `json:"inodes_percent"`
+}
+
+// InterfaceStat is the traffic of one network interface, Rx and Tx are
+// bytes per second, the other counters are totals of the report interval.
+type InterfaceStat struct {
+	Name        string `json:"name"`
+	Rx          uint64 `json:"rx"`
+	Tx          uint64 `json:"tx"`
+	Recv        uint64 `json:"rb"`
+	Send        uint64 `json:"sb"`
+	PacketsRecv uint64 `json:"packets_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	ErrIn       uint64 `json:"err_in"`
+	ErrOut      uint64 `json:"err_out"`
+	DropIn      uint64 `json:"drop_in"`
+	DropOut     uint64 `json:"drop_out"`
+}
+
+// SensorStat is the reading of one hardware sensor, the thresholds are zero
+// when the sensor has no such threshold.
+type SensorStat struct {
+	Chip  string  `json:"chip"`
+	Name  string  `json:"name"`
+	Label string  `json:"label"`
+	Type  string  `json:"type"`
+	Unit  string  `json:"unit"`
+	Value float64 `json:"value"`
+	Min   float64 `json:"min,omitempty"`
+	Max   float64 `json:"max,omitempty"`
+	Crit  float64 `json:"crit,omitempty"`
+}
+
+// Sensor types.
+const (
+	SensorTemperature = "temperature"
+	SensorFan         = "fan"
+	SensorVoltage     = "voltage"
+	SensorPower       = "power"
+)
+
+// SensorUnits maps sensor types to the unit of their values.
+var SensorUnits = map[string]string{
+	SensorTemperature: "°C",
+	SensorFan:         "RPM",
+	SensorVoltage:     "V",
+	SensorPower:       "W",
+}
+
+// RegisterRequest is sent by an agent enrolling with the registration token.
+// Secret is generated by the agent for its enrollment, later requests for
+// the node must repeat it.
+type RegisterRequest struct {
+	NodeID   string `json:"node_id"`
+	Secret   string `json:"secret"`
+	Hostname string `json:"hostname"`
+	Platform string `json:"platform"`
+	Version  string `json:"version"`
+	Arch     string `json:"arch"`
+}
+
+// RegisterResponse tells an agent whether it was approved, Token is the
+// node token issued on approval.
+type RegisterResponse struct {
+	Status string `json:"status"`
+	Token  string `json:"token,omitempty"`
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"os"
+	"strings"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func init() {
+	Register("sqlite", Driver{
+		Open: sqlite.Open,
+		Init: func(db *gorm.DB) error {
+			return db.Exec("PRAGMA journal_mode=WAL;").Error
+		},
+		// sqlite creates a missing file on open
+		Exists: func(dsn string) error {
+			path, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+			_, err := os.Stat(path)
+			return err
+		},
+	})
+	Register("postgres", Driver{Open: postgres.Open})
+	Register("mysql", Driver{Open: mysql.Open})
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"gorm.io/gorm"
+)
+
+// beforeMigrate prepares tables of older versions for the indexes that
+// AutoMigrate is about to add.
+func beforeMigrate(db *gorm.DB) error {
+	// replayed agent batches could store a record twice before records
+	// became unique per node and second
+	if err := dedupe(db, &define.MeasureRecord{}, "ux_mr_node_time", "node_id", "timestamp"); err != nil {
+		return err
+	}
+	// a retried report could store its reboot event twice
+	if err := dedupe(db, &define.NodeEvent{}, "ux_ne_node_time", "node_id", "timestamp", "type"); err != nil {
+		return err
+	}
+	// the unique indexes replace the old lookup indexes
+	if err := dropIndex(db, &define.MeasureRecord{}, "ix_mr_node_time"); err != nil {
+		return err
+	}
+	if err := dropIndex(db, &define.NodeEvent{}, "ix_ne_node_time"); err != nil {
+		return err
+	}
+	// approved registrations kept their plain node token there
+	return dropColumn(db, &define.PendingNode{}, "credential")
+}
+
+func dropColumn(db *gorm.DB, model any, column string) error {
+	if m := db.Migrator(); m.HasTable(model) && m.HasColumn(model, column) {
+		return m.DropColumn(model, column)
+	}
+	return nil
+}
+
+func dropIndex(db *gorm.DB, model any, index string) error {
+	if m := db.Migrator(); m.HasTable(model) && m.HasIndex(model, index) {
+		return m.DropIndex(model, index)
+	}
+	return nil
+}
+
+// dedupe keeps the oldest row of each group of columns, unless the unique
+// index over them already exists.
+func dedupe(db *gorm.DB, model any, index string, columns ...string) error {
+	m := db.Migrator()
+	if !m.HasTable(model) || m.HasIndex(model, index) {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	table := stmt.Schema.Table
+	// the derived table lets mysql delete from the table it reads
+	sql := fmt.Sprintf("DELETE FROM %s WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM %s GROUP BY %s) AS keep_rows)",
+		table, table, strings.Join(columns, ", "))
+	return db.Exec(sql).Error
+}
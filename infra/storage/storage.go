@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	slogGorm "github.com/orandin/slog-gorm"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"gorm.io/gorm"
+)
+
+// Driver is a database backend. Records are kept through gorm, so a driver
+// only has to open its dialect and tune new connections.
+type Driver struct {
+	Open func(dsn string) gorm.Dialector
+	// Init runs once after the database is opened, it may be nil.
+	Init func(db *gorm.DB) error
+	// Exists checks the database at dsn exists before OpenExisting, it may
+	// be nil for servers that refuse unknown databases anyway.
+	Exists func(dsn string) error
+}
+
+const DefaultDriver = "sqlite"
+
+var drivers = make(map[string]Driver)
+
+// Register adds a driver under name.
+func Register(name string, driver Driver) {
+	drivers[name] = driver
+}
+
+// Names returns every registered driver name.
+func Names() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Models lists the tables created by Open.
+var Models = []any{
+	&define.MeasureRecord{},
+	&define.MeasureRollup{},
+	&define.TrafficLedger{},
+	&define.NodeEvent{},
+	&define.PendingNode{},
+}
+
+func lookup(driverName string) (Driver, error) {
+	if driverName == "" {
+		driverName = DefaultDriver
+	}
+	driver, ok := drivers[driverName]
+	if !ok {
+		return driver, fmt.Errorf("unknown database driver %q, available: %s", driverName, strings.Join(Names(), ","))
+	}
+	return driver, nil
+}
+
+func open(driver Driver, dsn string) (*gorm.DB, error) {
+	return gorm.Open(driver.Open(dsn), &gorm.Config{
+		Logger: slogGorm.New(),
+	})
+}
+
+// OpenExisting connects to an existing database of driver at dsn as it is,
+// for tools reading the database of a server. Nothing is created, migrated
+// or tuned.
+func OpenExisting(driverName, dsn string) (*gorm.DB, error) {
+	driver, err := lookup(driverName)
+	if err != nil {
+		return nil, err
+	}
+	if driver.Exists != nil {
+		if err = driver.Exists(dsn); err != nil {
+			return nil, err
+		}
+	}
+	return open(driver, dsn)
+}
+
+// Open connects to the database of driver at dsn and migrates the tables.
+func Open(driverName, dsn string) (*gorm.DB, error) {
+	driver, err := lookup(driverName)
+	if err != nil {
+		return nil, err
+	}
+	db, err := open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver.Init != nil {
+		if err = driver.Init(db); err != nil {
+			return nil, err
+		}
+	}
+	if err = beforeMigrate(db); err != nil {
+		return nil, err
+	}
+	if err = db.AutoMigrate(Models...); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
@@ -1,6 +1,9 @@
 package vars
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/zjyl1994/cloudstatus/infra/define"
 	"gorm.io/gorm"
@@ -10,7 +13,14 @@ var (
 	DebugMode        bool
 	App              *fiber.App
 	DB               *gorm.DB
-	Config           define.ServerConfig
 	Listen           string
+	ConfigFile       string
 	NodeAliveTimeout int
+	// Config is swapped as a whole on reload, load it once per request to
+	// work on a consistent snapshot.
+	Config atomic.Pointer[define.ServerConfig]
 )
+
+func init() {
+	Config.Store(&define.ServerConfig{Location: time.Local})
+}
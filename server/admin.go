@@ -0,0 +1,233 @@
+package server
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+)
+
+type adminTitleReq struct {
+	Title string `json:"title"`
+}
+
+type adminOrderReq struct {
+	IDs []string `json:"ids"`
+}
+
+type adminTokenResp struct {
+	Token string `json:"token"`
+}
+
+// adminNodeResp is a created node, with its token when the server
+// generated it.
+type adminNodeResp struct {
+	define.ServerNode
+	Token string `json:"token,omitempty"`
+}
+
+// adminAuth only lets requests with the admin token through.
+func adminAuth(c *fiber.Ctx) error {
+	adminToken := vars.Config.Load().AdminToken
+	if adminToken == "" {
+		return c.Status(fiber.StatusForbidden).SendString("Admin API disabled")
+	}
+	if !matchToken(adminToken, bearerToken(c)) {
+		return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
+	}
+	return c.Next()
+}
+
+// updateConfig applies fn to a copy of the current config, then validates,
+// saves and applies the result. fn rejects the change with a fiber error.
+func updateConfig(fn func(cfg *define.ServerConfig) error) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	old := vars.Config.Load()
+	cfg := *old
+	cfg.Nodes = slices.Clone(old.Nodes)
+	if err := fn(&cfg); err != nil {
+		return err
+	}
+	if err := ValidateConfig(&cfg); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := SaveConfig(vars.ConfigFile, &cfg); err != nil {
+		return err
+	}
+	if err := ApplyConfig(&cfg); err != nil {
+		return err
+	}
+	logConfigDiff(old, &cfg)
+	return nil
+}
+
+var (
+	errNodeNotFound = fiber.NewError(fiber.StatusNotFound, "Node not found")
+	errNodeOrder    = fiber.NewError(fiber.StatusBadRequest, "Order must list every node once")
+)
+
+func nodeIndex(nodes []define.ServerNode, id string) int {
+	return slices.IndexFunc(nodes, func(n define.ServerNode) bool { return n.ID == id })
+}
+
+func handleAdminNodes(c *fiber.Ctx) error {
+	nodes := vars.Config.Load().Nodes
+	result := make([]define.ServerNode, 0, len(nodes))
+	for _, node := range nodes {
+		result = append(result, node.Public())
+	}
+	return c.JSON(result)
+}
+
+// handleAdminCreateNode adds a node. A given token is kept as its digest,
+// without one a token is generated and shown once.
+func handleAdminCreateNode(c *fiber.Ctx) error {
+	var node define.ServerNode
+	if err := c.BodyParser(&node); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	var token string
+	if node.Token == "" {
+		var err error
+		if token, node.Token, err = newToken(); err != nil {
+			return err
+		}
+	} else {
+		node.Token = hashToken(node.Token)
+	}
+	err := updateConfig(func(cfg *define.ServerConfig) error {
+		if nodeIndex(cfg.Nodes, node.ID) >= 0 {
+			return fiber.NewError(fiber.StatusConflict, "Node already exists")
+		}
+		cfg.Nodes = append(cfg.Nodes, node)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(adminNodeResp{ServerNode: node.Public(), Token: token})
+}
+
+// handleAdminUpdateNode replaces a node, its token is kept since tokens are
+// only changed by rotation.
+func handleAdminUpdateNode(c *fiber.Ctx) error {
+	var node define.ServerNode
+	if err := c.BodyParser(&node); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	// params point into the request buffer, which fiber reuses
+	node.ID = strings.Clone(c.Params("id"))
+	err := updateConfig(func(cfg *define.ServerConfig) error {
+		i := nodeIndex(cfg.Nodes, node.ID)
+		if i < 0 {
+			return errNodeNotFound
+		}
+		node.Token = cfg.Nodes[i].Token
+		cfg.Nodes[i] = node
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(node.Public())
+}
+
+func handleAdminDeleteNode(c *fiber.Ctx) error {
+	id := c.Params("id")
+	err := updateConfig(func(cfg *define.ServerConfig) error {
+		i := nodeIndex(cfg.Nodes, id)
+		if i < 0 {
+			return errNodeNotFound
+		}
+		cfg.Nodes = slices.Delete(cfg.Nodes, i, i+1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleAdminOrderNodes reorders nodes, ids must list every node once.
+func handleAdminOrderNodes(c *fiber.Ctx) error {
+	var req adminOrderReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	err := updateConfig(func(cfg *define.ServerConfig) error {
+		if len(req.IDs) != len(cfg.Nodes) {
+			return errNodeOrder
+		}
+		nodes := make([]define.ServerNode, 0, len(req.IDs))
+		for _, id := range req.IDs {
+			i := nodeIndex(cfg.Nodes, id)
+			if i < 0 || nodeIndex(nodes, id) >= 0 {
+				return errNodeOrder
+			}
+			nodes = append(nodes, cfg.Nodes[i])
+		}
+		cfg.Nodes = nodes
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return handleAdminNodes(c)
+}
+
+// handleAdminRotateNodeToken gives a node its own new token, only the
+// digest is kept so the token is shown once.
+func handleAdminRotateNodeToken(c *fiber.Ctx) error {
+	id := c.Params("id")
+	token, digest, err := newToken()
+	if err != nil {
+		return err
+	}
+	err = updateConfig(func(cfg *define.ServerConfig) error {
+		i := nodeIndex(cfg.Nodes, id)
+		if i < 0 {
+			return errNodeNotFound
+		}
+		cfg.Nodes[i].Token = digest
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(adminTokenResp{Token: token})
+}
+
+// handleAdminRotateToken replaces the global report token.
+func handleAdminRotateToken(c *fiber.Ctx) error {
+	token, digest, err := newToken()
+	if err != nil {
+		return err
+	}
+	err = updateConfig(func(cfg *define.ServerConfig) error {
+		cfg.Token = digest
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(adminTokenResp{Token: token})
+}
+
+func handleAdminTitle(c *fiber.Ctx) error {
+	var req adminTitleReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	err := updateConfig(func(cfg *define.ServerConfig) error {
+		cfg.Title = req.Title
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(req)
+}
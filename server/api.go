@@ -2,9 +2,13 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,6 +17,7 @@ import (
 	"github.com/zjyl1994/cloudstatus/infra/define"
 	"github.com/zjyl1994/cloudstatus/infra/rwmap"
 	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/alert"
 	"github.com/zjyl1994/cloudstatus/service/record"
 	"golang.org/x/sync/singleflight"
 )
@@ -24,31 +29,135 @@ var (
 )
 
 func handleAPIReport(c *fiber.Ctx) error {
-	// check token
-	authHeader := c.Get(fiber.HeaderAuthorization)
-	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
-	authHeader = strings.TrimSpace(authHeader)
-	if authHeader != vars.Config.Token {
-		return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
-	}
 	// parse data
 	var data define.StatExchangeFormat
 	err := cbor.Unmarshal(c.Body(), &data)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
+	// check token bound to the reporting node
+	if !checkReportToken(data.NodeID, bearerToken(c)) {
+		return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
+	}
 	if vars.DebugMode {
 		slog.Debug("Receive data", slog.Any("data", data))
 	}
-	// save data
-	statCache.Set(data.NodeID, data)
+	// save data, the live state and events follow once the record is
+	// queued, a report retried after a full queue is seen only once
+	prev := cachedStat(data.NodeID)
 	err = record.WriteRecord(&data)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		return writeError(c, err)
+	}
+	if record.DetectReboot(prev, &data) {
+		saveRebootEvent(&data)
 	}
+	statCache.Set(data.NodeID, data)
+	alert.Evaluate(&data)
+	// the first report since start, a registered agent is done enrolling
+	if prev == nil {
+		forgetRegistration(data.NodeID)
+	}
+	publishStat(data)
 	return c.SendStatus(fiber.StatusOK)
 }
 
+// handleAPIBatchReport accepts samples buffered by an agent while the
+// server was unreachable, in the order they were measured.
+func handleAPIBatchReport(c *fiber.Ctx) error {
+	// parse data
+	var batch []define.StatExchangeFormat
+	err := cbor.Unmarshal(c.Body(), &batch)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if len(batch) == 0 {
+		return c.SendStatus(fiber.StatusOK)
+	}
+	// check token bound to the reporting node
+	token := bearerToken(c)
+	for _, data := range batch {
+		if !checkReportToken(data.NodeID, token) {
+			return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
+		}
+	}
+	if vars.DebugMode {
+		slog.Debug("Receive batch", slog.Int("count", len(batch)))
+	}
+	// save data
+	prevs := make(map[string]*define.StatExchangeFormat)
+	var first []string
+	var rebooted []*define.StatExchangeFormat
+	for i := range batch {
+		prev, ok := prevs[batch[i].NodeID]
+		if !ok {
+			prev = cachedStat(batch[i].NodeID)
+			if prev == nil {
+				first = append(first, batch[i].NodeID)
+			}
+		}
+		if record.DetectReboot(prev, &batch[i]) {
+			rebooted = append(rebooted, &batch[i])
+		}
+		prevs[batch[i].NodeID] = &batch[i]
+	}
+	if err = record.WriteRecords(batch); err != nil {
+		return writeError(c, err)
+	}
+	for _, data := range rebooted {
+		saveRebootEvent(data)
+	}
+	for _, nodeId := range first {
+		forgetRegistration(nodeId)
+	}
+	// only the newest sample of each node is live state
+	latest := make(map[string]define.StatExchangeFormat)
+	for _, data := range batch {
+		if last, ok := latest[data.NodeID]; !ok || data.ReportTime >= last.ReportTime {
+			latest[data.NodeID] = data
+		}
+	}
+	for nodeId, data := range latest {
+		if cached, ok := statCache.Get(nodeId); ok && cached.ReportTime > data.ReportTime {
+			continue
+		}
+		statCache.Set(nodeId, data)
+		alert.Evaluate(&data)
+		publishStat(data)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// writeError answers a failed record write. A full or stopping write queue
+// is temporary, the agent keeps the sample and retries. A bad record is
+// dropped by the agent.
+func writeError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, record.ErrQueueFull) || errors.Is(err, record.ErrWriterStopped) {
+		c.Set(fiber.HeaderRetryAfter, "5")
+		return c.Status(fiber.StatusServiceUnavailable).SendString(err.Error())
+	}
+	if errors.Is(err, record.ErrBadRecord) {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+}
+
+// cachedStat returns the latest cached stat of a node, or nil.
+func cachedStat(nodeId string) *define.StatExchangeFormat {
+	if cached, ok := statCache.Get(nodeId); ok {
+		return &cached
+	}
+	return nil
+}
+
+// saveRebootEvent records a reboot event for the report, errors are only
+// logged.
+func saveRebootEvent(data *define.StatExchangeFormat) {
+	if err := record.WriteRebootEvent(data); err != nil {
+		slog.Error("Write reboot event", slog.String("err", err.Error()))
+	}
+}
+
 type overviewResponse struct {
 	UpdateAt int64                       `json:"update_at"`
 	Nodes    []define.StatExchangeFormat `json:"nodes"`
@@ -68,39 +177,20 @@ func handleOverview(c *fiber.Ctx) error {
 			tm[t.NodeId] = t
 		}
 		// get all node stat from cache
-		result := make([]define.StatExchangeFormat, 0, len(vars.Config.Nodes))
-		for _, node := range vars.Config.Nodes {
+		nodes := vars.Config.Load().Nodes
+		result := make([]define.StatExchangeFormat, 0, len(nodes))
+		for _, node := range nodes {
 			stat, ok := statCache.Get(node.ID)
 			if !ok {
 				result = append(result, define.StatExchangeFormat{
 					NodeID:    node.ID,
-					Metadata:  node,
+					Metadata:  node.Public(),
 					NodeAlive: false,
 				})
 				continue
 			}
 
-			stat.Metadata = node
-			stat.NodeAlive = (time.Now().Unix() - stat.ReportTime) < int64(vars.NodeAliveTimeout)
-
-			// set monthly traffic data
-			if td, ok := tm[node.ID]; ok {
-				stat.Network.Send = td.NetSend
-				stat.Network.Recv = td.NetRecv
-			}
-
-			// format float values
-			stat.Percent.CPU = formatFloat(stat.Percent.CPU)
-			stat.Percent.Mem = formatFloat(stat.Percent.Mem)
-			stat.Percent.Swap = formatFloat(stat.Percent.Swap)
-			stat.Load.Load1 = formatFloat(stat.Load.Load1)
-			stat.Load.Load5 = formatFloat(stat.Load.Load5)
-			stat.Load.Load15 = formatFloat(stat.Load.Load15)
-			if stat.Temperature != nil {
-				stat.Temperature = formatFloatMap(stat.Temperature)
-			}
-
-			result = append(result, stat)
+			result = append(result, decorateStat(node, stat, tm[node.ID]))
 		}
 
 		return overviewResponse{UpdateAt: now, Nodes: result}, nil
@@ -108,10 +198,82 @@ func handleOverview(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
-	return c.JSON(ret)
+	// the shared result holds every node, drop the private ones per viewer
+	resp := ret.(overviewResponse)
+	if !isViewer(c) {
+		nodes := make([]define.StatExchangeFormat, 0, len(resp.Nodes))
+		for _, stat := range resp.Nodes {
+			if !stat.Metadata.Private {
+				nodes = append(nodes, stat)
+			}
+		}
+		resp.Nodes = nodes
+	}
+	return c.JSON(resp)
+}
+
+// decorateStat fills node metadata and cycle traffic into a cached stat and
+// rounds its float values for display.
+func decorateStat(node define.ServerNode, stat define.StatExchangeFormat, td define.TrafficCalcResult) define.StatExchangeFormat {
+	stat.Metadata = node.Public()
+	stat.NodeAlive = (time.Now().Unix() - stat.ReportTime) < int64(vars.NodeAliveTimeout)
+
+	// set monthly traffic data
+	stat.Network.Send = td.NetSend
+	stat.Network.Recv = td.NetRecv
+	stat.Network.Quota = record.CalcQuota(node, td.NetSend, td.NetRecv, time.Now())
+	if stat.Network.Quota != nil {
+		stat.Network.Quota.Percent = formatFloat(stat.Network.Quota.Percent)
+		stat.Network.Quota.ProjectedPercent = formatFloat(stat.Network.Quota.ProjectedPercent)
+	}
+
+	// format float values
+	stat.Percent.CPU = formatFloat(stat.Percent.CPU)
+	stat.CPU = formatCPUStat(stat.CPU)
+	stat.Percent.Mem = formatFloat(stat.Percent.Mem)
+	stat.Percent.Swap = formatFloat(stat.Percent.Swap)
+	stat.Load.Load1 = formatFloat(stat.Load.Load1)
+	stat.Load.Load5 = formatFloat(stat.Load.Load5)
+	stat.Load.Load15 = formatFloat(stat.Load.Load15)
+	if stat.Temperature != nil {
+		stat.Temperature = formatFloatMap(stat.Temperature)
+	}
+	for i := range stat.Sensors {
+		stat.Sensors[i].Value = formatFloat(stat.Sensors[i].Value)
+	}
+	stat.Disk.Mounts = selectMounts(node, stat.Disk.Mounts)
+	return stat
+}
+
+// selectMounts keeps the mounts chosen for node in its order, or sorts all
+// mounts fullest first, and rounds the percent values.
+func selectMounts(node define.ServerNode, mounts []define.MountStat) []define.MountStat {
+	result := make([]define.MountStat, 0, len(mounts))
+	if len(node.Mounts) > 0 {
+		for _, path := range node.Mounts {
+			for _, m := range mounts {
+				if m.Path == path {
+					result = append(result, m)
+				}
+			}
+		}
+	} else {
+		result = append(result, mounts...)
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Percent > result[j].Percent
+		})
+	}
+	for i := range result {
+		result[i].Percent = formatFloat(result[i].Percent)
+		result[i].InodesPercent = formatFloat(result[i].InodesPercent)
+	}
+	return result
 }
 
 type ChartsResponse struct {
+	Resolution  int64                          `json:"resolution"`
+	Step        int64                          `json:"step"`
+	Agg         string                         `json:"agg"`
 	CPU         []ChartsPercentItem            `json:"cpu"`
 	Memory      []ChartsPercentItem            `json:"memory"`
 	Swap        []ChartsPercentItem            `json:"swap"`
@@ -119,24 +281,44 @@ type ChartsResponse struct {
 	NetSpeed    []ChartsSpeedItem              `json:"net_speed"`
 	Load        []ChartsLoadItem               `json:"load"`
 	Temperature map[string][]ChartsPercentItem `json:"temperature"`
+	CPUTimes    map[string][]ChartsPercentItem `json:"cpu_times"`
+	CPUCores    map[string][]ChartsPercentItem `json:"cpu_cores"`
+	Mounts      map[string][]ChartsPercentItem `json:"mounts"`
+	// series by interface then counter name
+	Interfaces map[string]map[string][]ChartsPercentItem `json:"interfaces"`
+	// series by sensor type then sensor name
+	Sensors     map[string]map[string][]ChartsPercentItem `json:"sensors"`
+	SensorUnits map[string]string                         `json:"sensor_units"`
+}
+
+// chartMetrics lists the series of ChartsResponse by json name, the
+// metrics query parameter picks among them.
+var chartMetrics = []string{"cpu", "memory", "swap", "disk_speed", "net_speed", "load",
+	"temperature", "cpu_times", "cpu_cores", "mounts", "interfaces", "sensors"}
+
+// ChartsTime stamps a chart point with the local time and, when asked
+// for, the unix time.
+type ChartsTime struct {
+	DateTime  string `json:"time"`
+	Timestamp int64  `json:"ts,omitempty"`
 }
 
 type ChartsPercentItem struct {
-	DateTime string  `json:"time"`
-	Value    float64 `json:"value"`
+	ChartsTime
+	Value float64 `json:"value"`
 }
 
 type ChartsSpeedItem struct {
-	DateTime string `json:"time"`
-	Rx       int64  `json:"rx"`
-	Tx       int64  `json:"tx"`
+	ChartsTime
+	Rx int64 `json:"rx"`
+	Tx int64 `json:"tx"`
 }
 
 type ChartsLoadItem struct {
-	DateTime string  `json:"time"`
-	Load1    float64 `json:"load1"`
-	Load5    float64 `json:"load5"`
-	Load15   float64 `json:"load15"`
+	ChartsTime
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
 }
 
 func handleCharts(c *fiber.Ctx) error {
@@ -156,17 +338,63 @@ func handleCharts(c *fiber.Ctx) error {
 	if startTime > endTime {
 		return c.Status(fiber.StatusBadRequest).SendString("Start time must be less than end time")
 	}
-	sresp, err, _ := chartsSf.Do(fmt.Sprintf("charts-%s-%d-%d", nodeId, startTime, endTime), func() (interface{}, error) {
-		// load data
-		mrList, err := record.LoadRecord(nodeId, int64(startTime), int64(endTime))
+	step, err := parseStep(c.Query("step"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	agg, err := parseAgg(c.Query("agg", record.AggAvg))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	// series to return, all when empty
+	var metrics map[string]struct{}
+	if q := c.Query("metrics"); q != "" {
+		metrics = make(map[string]struct{})
+		for _, name := range strings.Split(q, ",") {
+			if !slices.Contains(chartMetrics, name) {
+				return c.Status(fiber.StatusBadRequest).SendString("Unknown metric " + name + ", available: " + strings.Join(chartMetrics, ","))
+			}
+			metrics[strings.Clone(name)] = struct{}{}
+		}
+	}
+	want := func(name string) bool {
+		_, ok := metrics[name]
+		return metrics == nil || ok
+	}
+	withUnix := c.QueryBool("unix")
+	if err := checkNodeView(c, nodeId); err != nil {
+		return err
+	}
+	// mounts to chart: from query, from node config, or the fullest ones.
+	// query strings point into the request buffer, the shared singleflight
+	// result must not
+	var mounts []string
+	if q := c.Query("mounts"); q != "" {
+		mounts = strings.Split(strings.Clone(q), ",")
+	} else if node, ok := findNode(nodeId); ok {
+		mounts = node.Mounts
+	}
+	// interfaces to chart, all when empty
+	var interfaces map[string]struct{}
+	if q := c.Query("interfaces"); q != "" {
+		interfaces = make(map[string]struct{})
+		for _, name := range strings.Split(strings.Clone(q), ",") {
+			interfaces[name] = struct{}{}
+		}
+	}
+	sfKey := fmt.Sprintf("charts-%s-%d-%d-%d-%s-%t-%s-%s-%s", nodeId, startTime, endTime, step, agg, withUnix,
+		c.Query("metrics"), strings.Join(mounts, ","), c.Query("interfaces"))
+	sresp, err, _ := chartsSf.Do(sfKey, func() (interface{}, error) {
+		// load data, long windows are served from rollups
+		resolution, mrList, err := record.LoadSeries(nodeId, int64(startTime), int64(endTime), int64(step), agg)
 		if err != nil {
 			return nil, err
 		}
-		sort.Slice(mrList, func(i, j int) bool {
-			return mrList[i].Timestamp < mrList[j].Timestamp
-		})
 		// convert to resp
 		resp := ChartsResponse{
+			Resolution:  resolution,
+			Step:        step,
+			Agg:         agg,
 			CPU:         make([]ChartsPercentItem, 0, len(mrList)),
 			Memory:      make([]ChartsPercentItem, 0, len(mrList)),
 			Swap:        make([]ChartsPercentItem, 0, len(mrList)),
@@ -174,48 +402,74 @@ func handleCharts(c *fiber.Ctx) error {
 			NetSpeed:    make([]ChartsSpeedItem, 0, len(mrList)),
 			Load:        make([]ChartsLoadItem, 0, len(mrList)),
 			Temperature: make(map[string][]ChartsPercentItem),
+			CPUTimes:    make(map[string][]ChartsPercentItem),
+			CPUCores:    make(map[string][]ChartsPercentItem),
+			Mounts:      make(map[string][]ChartsPercentItem),
+			Interfaces:  make(map[string]map[string][]ChartsPercentItem),
+			Sensors:     make(map[string]map[string][]ChartsPercentItem),
+			SensorUnits: define.SensorUnits,
 		}
 		for _, mr := range mrList {
-			dateTime := time.Unix(mr.Timestamp, 0).Format(time.DateTime)
-			resp.CPU = append(resp.CPU, ChartsPercentItem{
-				DateTime: dateTime,
-				Value:    formatFloat(mr.CPU),
-			})
-			resp.Memory = append(resp.Memory, ChartsPercentItem{
-				DateTime: dateTime,
-				Value:    formatFloat(mr.Memory),
-			})
-			resp.Swap = append(resp.Swap, ChartsPercentItem{
-				DateTime: dateTime,
-				Value:    formatFloat(mr.Swap),
-			})
-			resp.DiskSpeed = append(resp.DiskSpeed, ChartsSpeedItem{
-				DateTime: dateTime,
-				Rx:       int64(mr.DiskRx),
-				Tx:       int64(mr.DiskWx),
-			})
-			resp.NetSpeed = append(resp.NetSpeed, ChartsSpeedItem{
-				DateTime: dateTime,
-				Rx:       int64(mr.NetRx),
-				Tx:       int64(mr.NetTx),
-			})
-			resp.Load = append(resp.Load, ChartsLoadItem{
-				DateTime: dateTime,
-				Load1:    formatFloat(mr.Load1),
-				Load5:    formatFloat(mr.Load5),
-				Load15:   formatFloat(mr.Load15),
-			})
-			var tempMap map[string]float64
-			err = json.Unmarshal([]byte(mr.Temperature), &tempMap)
-			if err == nil {
-				tempMap = formatFloatMap(tempMap)
-				for k, v := range tempMap {
-					resp.Temperature[k] = append(resp.Temperature[k], ChartsPercentItem{
-						DateTime: dateTime,
-						Value:    v,
-					})
-				}
+			t := ChartsTime{DateTime: time.Unix(mr.Timestamp, 0).Format(time.DateTime)}
+			if withUnix {
+				t.Timestamp = mr.Timestamp
+			}
+			if want("cpu") {
+				resp.CPU = append(resp.CPU, ChartsPercentItem{ChartsTime: t, Value: formatFloat(mr.CPU)})
+			}
+			if want("memory") {
+				resp.Memory = append(resp.Memory, ChartsPercentItem{ChartsTime: t, Value: formatFloat(mr.Memory)})
+			}
+			if want("swap") {
+				resp.Swap = append(resp.Swap, ChartsPercentItem{ChartsTime: t, Value: formatFloat(mr.Swap)})
+			}
+			if want("disk_speed") {
+				resp.DiskSpeed = append(resp.DiskSpeed, ChartsSpeedItem{ChartsTime: t, Rx: int64(mr.DiskRx), Tx: int64(mr.DiskWx)})
+			}
+			if want("net_speed") {
+				resp.NetSpeed = append(resp.NetSpeed, ChartsSpeedItem{ChartsTime: t, Rx: int64(mr.NetRx), Tx: int64(mr.NetTx)})
+			}
+			if want("load") {
+				resp.Load = append(resp.Load, ChartsLoadItem{
+					ChartsTime: t,
+					Load1:      formatFloat(mr.Load1),
+					Load5:      formatFloat(mr.Load5),
+					Load15:     formatFloat(mr.Load15),
+				})
+			}
+			if want("temperature") {
+				appendMapSeries(resp.Temperature, mr.Temperature, t)
 			}
+			if want("cpu_cores") {
+				appendMapSeries(resp.CPUCores, mr.CPUCores, t)
+			}
+			if want("mounts") {
+				appendMapSeries(resp.Mounts, mr.Mounts, t)
+			}
+			if want("interfaces") {
+				appendGroupSeries(resp.Interfaces, interfaces, mr.Interfaces, t)
+			}
+			if want("sensors") {
+				appendGroupSeries(resp.Sensors, nil, mr.Sensors, t)
+			}
+			if !want("cpu_times") {
+				continue
+			}
+			for mode, value := range map[string]float64{
+				"user":    mr.CPUUser,
+				"system":  mr.CPUSystem,
+				"nice":    mr.CPUNice,
+				"iowait":  mr.CPUIowait,
+				"irq":     mr.CPUIrq,
+				"softirq": mr.CPUSoftirq,
+				"steal":   mr.CPUSteal,
+			} {
+				resp.CPUTimes[mode] = append(resp.CPUTimes[mode], ChartsPercentItem{ChartsTime: t, Value: formatFloat(value)})
+			}
+		}
+		resp.Mounts = pickMountSeries(resp.Mounts, mounts)
+		if metrics != nil {
+			return selectChartMetrics(resp, metrics), nil
 		}
 		return resp, nil
 	})
@@ -227,19 +481,178 @@ func handleCharts(c *fiber.Ctx) error {
 	return c.JSON(sresp)
 }
 
+// parseStep reads a bucket step as seconds or as a duration such as 5m.
+func parseStep(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+		return n, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid step %q, use seconds or a duration such as 5m", s)
+	}
+	return int64(d / time.Second), nil
+}
+
+// parseAgg returns the series aggregate named s. The query string points
+// into the request buffer, the constant is returned instead.
+func parseAgg(s string) (string, error) {
+	i := slices.Index(record.SeriesAggs, s)
+	if i < 0 {
+		return "", fmt.Errorf("unknown agg %q, available: %s", s, strings.Join(record.SeriesAggs, ","))
+	}
+	return record.SeriesAggs[i], nil
+}
+
+// selectChartMetrics keeps the requested series of resp, keyed by their
+// json names, along with the fields describing the response.
+func selectChartMetrics(resp ChartsResponse, metrics map[string]struct{}) map[string]any {
+	result := map[string]any{
+		"resolution": resp.Resolution,
+		"step":       resp.Step,
+		"agg":        resp.Agg,
+	}
+	if _, ok := metrics["sensors"]; ok {
+		result["sensor_units"] = resp.SensorUnits
+	}
+	v := reflect.ValueOf(resp)
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if _, ok := metrics[name]; ok {
+			result[name] = v.Field(i).Interface()
+		}
+	}
+	return result
+}
+
+// maxMountSeries limits the mount series charted when none are selected.
+const maxMountSeries = 5
+
+// pickMountSeries keeps the selected mount series, or the ones with the
+// highest peak usage when nothing is selected.
+func pickMountSeries(series map[string][]ChartsPercentItem, selected []string) map[string][]ChartsPercentItem {
+	result := make(map[string][]ChartsPercentItem)
+	if len(selected) > 0 {
+		for _, path := range selected {
+			if items, ok := series[path]; ok {
+				result[path] = items
+			}
+		}
+		return result
+	}
+	peaks := make(map[string]float64, len(series))
+	paths := make([]string, 0, len(series))
+	for path, items := range series {
+		for _, item := range items {
+			peaks[path] = max(peaks[path], item.Value)
+		}
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return peaks[paths[i]] > peaks[paths[j]]
+	})
+	for _, path := range paths[:min(len(paths), maxMountSeries)] {
+		result[path] = series[path]
+	}
+	return result
+}
+
+// appendGroupSeries appends the values of a JSON encoded map keyed
+// "<group>/<name>" to the series of the selected groups, all groups are
+// kept when selected is nil.
+func appendGroupSeries(series map[string]map[string][]ChartsPercentItem, selected map[string]struct{}, raw string, t ChartsTime) {
+	var values map[string]float64
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return
+	}
+	for k, v := range values {
+		group, name, ok := strings.Cut(k, "/")
+		if !ok {
+			continue
+		}
+		if _, ok := selected[group]; selected != nil && !ok {
+			continue
+		}
+		if series[group] == nil {
+			series[group] = make(map[string][]ChartsPercentItem)
+		}
+		series[group][name] = append(series[group][name], ChartsPercentItem{ChartsTime: t, Value: formatFloat(v)})
+	}
+}
+
+// appendMapSeries appends the values of a JSON encoded map[string]float64
+// column to the series of each key.
+func appendMapSeries(series map[string][]ChartsPercentItem, raw string, t ChartsTime) {
+	var values map[string]float64
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return
+	}
+	for k, v := range values {
+		series[k] = append(series[k], ChartsPercentItem{ChartsTime: t, Value: formatFloat(v)})
+	}
+}
+
 type nodeResp struct {
 	Title string              `json:"title"`
 	Nodes []define.ServerNode `json:"nodes"`
 }
 
 func handleNodes(c *fiber.Ctx) error {
-	title := vars.Config.Title
+	cfg := vars.Config.Load()
+	title := cfg.Title
 	if title == "" {
 		title = "Cloudstatus"
 	}
+	nodes := make([]define.ServerNode, 0, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		if canView(c, node) {
+			nodes = append(nodes, node.Public())
+		}
+	}
 	resp := nodeResp{
 		Title: title,
-		Nodes: vars.Config.Nodes,
+		Nodes: nodes,
 	}
 	return c.JSON(resp)
 }
+
+func handleTraffic(c *fiber.Ctx) error {
+	nodeId := c.Query("id")
+	if nodeId == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Missing node id")
+	}
+	if err := checkNodeView(c, nodeId); err != nil {
+		return err
+	}
+	cycles, err := record.ListTrafficCycles(nodeId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	return c.JSON(cycles)
+}
+
+func handleEvents(c *fiber.Ctx) error {
+	now := time.Now().Unix()
+	nodeId := c.Query("id")
+	if nodeId == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Missing node id")
+	}
+	endTime := c.QueryInt("end")
+	if endTime == 0 {
+		endTime = int(now)
+	}
+	startTime := c.QueryInt("start")
+	if startTime == 0 {
+		startTime = endTime - 30*86400
+	}
+	if err := checkNodeView(c, nodeId); err != nil {
+		return err
+	}
+	events, err := record.LoadEvent(nodeId, int64(startTime), int64(endTime))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	return c.JSON(events)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+)
+
+const tokenHashPrefix = "sha256:"
+
+// bearerToken extracts the bearer token from the Authorization header.
+func bearerToken(c *fiber.Ctx) string {
+	authHeader := c.Get(fiber.HeaderAuthorization)
+	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
+	return strings.TrimSpace(authHeader)
+}
+
+// findNode looks up a node by id in the current config.
+func findNode(id string) (define.ServerNode, bool) {
+	return configNode(vars.Config.Load(), id)
+}
+
+// configNode looks up a node by id in cfg.
+func configNode(cfg *define.ServerConfig, id string) (define.ServerNode, bool) {
+	for _, node := range cfg.Nodes {
+		if node.ID == id {
+			return node, true
+		}
+	}
+	return define.ServerNode{}, false
+}
+
+// checkReportToken reports whether token is allowed to report for nodeId.
+// Nodes with their own token only accept that token, others fall back to
+// the global token.
+func checkReportToken(nodeId, token string) bool {
+	cfg := vars.Config.Load()
+	if node, ok := configNode(cfg, nodeId); ok && node.Token != "" {
+		return matchToken(node.Token, token)
+	}
+	return matchToken(cfg.Token, token)
+}
+
+// newToken returns a random token and its digest in the form kept in config.
+func newToken() (token, digest string, err error) {
+	b := make([]byte, 24)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the digest form of a token, a digest is kept as is.
+func hashToken(token string) string {
+	if strings.HasPrefix(token, tokenHashPrefix) {
+		return token
+	}
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// matchToken compares token with expected, which is either plain text or
+// a "sha256:<hex>" digest.
+func matchToken(expected, token string) bool {
+	if digest, ok := strings.CutPrefix(expected, tokenHashPrefix); ok {
+		sum := sha256.Sum256([]byte(token))
+		expected = strings.ToLower(digest)
+		token = hex.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
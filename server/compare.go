@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/record"
+)
+
+const (
+	// compareMinStep aligns raw records, which each agent reports at its
+	// own second.
+	compareMinStep = 60
+	// maxCompareNodes limits the nodes of one comparison.
+	maxCompareNodes = 50
+	// maxComparePoints limits the length of the shared time axis.
+	maxComparePoints = 10000
+)
+
+// compareMetrics are the metrics a comparison can overlay, by name.
+var compareMetrics = map[string]func(mr *define.MeasureRecord) float64{
+	"cpu":        func(mr *define.MeasureRecord) float64 { return mr.CPU },
+	"cpu_user":   func(mr *define.MeasureRecord) float64 { return mr.CPUUser },
+	"cpu_system": func(mr *define.MeasureRecord) float64 { return mr.CPUSystem },
+	"cpu_iowait": func(mr *define.MeasureRecord) float64 { return mr.CPUIowait },
+	"cpu_steal":  func(mr *define.MeasureRecord) float64 { return mr.CPUSteal },
+	"memory":     func(mr *define.MeasureRecord) float64 { return mr.Memory },
+	"swap":       func(mr *define.MeasureRecord) float64 { return mr.Swap },
+	"disk":       func(mr *define.MeasureRecord) float64 { return mr.Disk },
+	"load1":      func(mr *define.MeasureRecord) float64 { return mr.Load1 },
+	"load5":      func(mr *define.MeasureRecord) float64 { return mr.Load5 },
+	"load15":     func(mr *define.MeasureRecord) float64 { return mr.Load15 },
+	"disk_read":  func(mr *define.MeasureRecord) float64 { return float64(mr.DiskRx) },
+	"disk_write": func(mr *define.MeasureRecord) float64 { return float64(mr.DiskWx) },
+	"net_rx":     func(mr *define.MeasureRecord) float64 { return float64(mr.NetRx) },
+	"net_tx":     func(mr *define.MeasureRecord) float64 { return float64(mr.NetTx) },
+}
+
+func compareMetricNames() []string {
+	names := make([]string, 0, len(compareMetrics))
+	for name := range compareMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type compareResponse struct {
+	Metric     string          `json:"metric"`
+	Agg        string          `json:"agg"`
+	Step       int64           `json:"step"`
+	Resolution int64           `json:"resolution"`
+	Times      []ChartsTime    `json:"times"`
+	Series     []compareSeries `json:"series"`
+}
+
+// compareSeries holds one value per entry of the shared time axis, null
+// where the node has no data.
+type compareSeries struct {
+	ID     string     `json:"id"`
+	Label  string     `json:"label"`
+	Values []*float64 `json:"values"`
+}
+
+// handleCompare returns one metric of several nodes, bucketed on a shared
+// time axis so the series can be overlaid. Nodes are given by ids or by
+// tag.
+func handleCompare(c *fiber.Ctx) error {
+	now := time.Now().Unix()
+	metric := c.Query("metric")
+	value, ok := compareMetrics[metric]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).SendString("Unknown metric, available: " + strings.Join(compareMetricNames(), ","))
+	}
+	endTime := int64(c.QueryInt("end"))
+	if endTime == 0 {
+		endTime = now
+	}
+	startTime := int64(c.QueryInt("start"))
+	if startTime == 0 {
+		startTime = endTime - 3600
+	}
+	if startTime > endTime {
+		return c.Status(fiber.StatusBadRequest).SendString("Start time must be less than end time")
+	}
+	step, err := parseStep(c.Query("step"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	agg, err := parseAgg(c.Query("agg", record.AggAvg))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	nodes, err := compareNodes(c)
+	if err != nil {
+		return err
+	}
+	// every node shares the step, at least the resolution the window is
+	// served from, so buckets line up
+	step = max(step, record.PickResolution(startTime, endTime, 0), compareMinStep)
+	first := startTime / step * step
+	points := (endTime-first)/step + 1
+	if points > maxComparePoints {
+		return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("Too many points, use a step of at least %d", (endTime-startTime)/maxComparePoints+1))
+	}
+
+	withUnix := c.QueryBool("unix")
+	resp := compareResponse{
+		Metric: metric,
+		Agg:    agg,
+		Step:   step,
+		Times:  make([]ChartsTime, points),
+		Series: make([]compareSeries, 0, len(nodes)),
+	}
+	for i := range resp.Times {
+		ts := first + int64(i)*step
+		resp.Times[i].DateTime = time.Unix(ts, 0).Format(time.DateTime)
+		if withUnix {
+			resp.Times[i].Timestamp = ts
+		}
+	}
+	for _, node := range nodes {
+		resolution, mrList, err := record.LoadSeries(node.ID, startTime, endTime, step, agg)
+		if err != nil {
+			return err
+		}
+		resp.Resolution = resolution
+		series := compareSeries{ID: node.ID, Label: node.Label, Values: make([]*float64, points)}
+		for i := range mrList {
+			idx := (mrList[i].Timestamp - first) / step
+			if idx < 0 || idx >= points {
+				continue
+			}
+			v := formatFloat(value(&mrList[i]))
+			series.Values[idx] = &v
+		}
+		resp.Series = append(resp.Series, series)
+	}
+	return c.JSON(resp)
+}
+
+// compareNodes returns the visible nodes named by the ids or tag query, in
+// config order for a tag and in query order for ids.
+func compareNodes(c *fiber.Ctx) ([]define.ServerNode, error) {
+	cfg := vars.Config.Load()
+	var nodes []define.ServerNode
+	switch {
+	case c.Query("ids") != "":
+		for _, id := range strings.Split(c.Query("ids"), ",") {
+			node, ok := configNode(cfg, strings.TrimSpace(id))
+			if !ok || !canView(c, node) {
+				return nil, errNodeNotFound
+			}
+			if !slices.ContainsFunc(nodes, func(n define.ServerNode) bool { return n.ID == node.ID }) {
+				nodes = append(nodes, node)
+			}
+		}
+	case c.Query("tag") != "":
+		tag := c.Query("tag")
+		for _, node := range cfg.Nodes {
+			if slices.Contains(node.Tags, tag) && canView(c, node) {
+				nodes = append(nodes, node)
+			}
+		}
+	default:
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Missing node ids or tag")
+	}
+	if len(nodes) > maxCompareNodes {
+		return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("At most %d nodes can be compared", maxCompareNodes))
+	}
+	return nodes, nil
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/alert"
+	"github.com/zjyl1994/cloudstatus/service/notify"
+	"github.com/zjyl1994/cloudstatus/service/record"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoadConfig reads and validates the server config file.
+func LoadConfig(configFile string) (define.ServerConfig, error) {
+	var cfg define.ServerConfig
+	bConf, err := os.ReadFile(configFile)
+	if err != nil {
+		return cfg, err
+	}
+	if err = json.Unmarshal(bConf, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, ValidateConfig(&cfg)
+}
+
+// ValidateConfig checks a config before it is applied.
+func ValidateConfig(cfg *define.ServerConfig) error {
+	if err := alert.Validate(cfg.Alerts); err != nil {
+		return err
+	}
+	ids := make(map[string]struct{}, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		if node.ID == "" {
+			return errors.New("node id is empty")
+		}
+		if _, ok := ids[node.ID]; ok {
+			return fmt.Errorf("duplicate node %q", node.ID)
+		}
+		ids[node.ID] = struct{}{}
+		if !slices.Contains(record.QuotaModes, node.QuotaMode) {
+			return fmt.Errorf("node %q: unknown quota mode %q", node.ID, node.QuotaMode)
+		}
+		if slices.Contains(node.Tags, "") {
+			return fmt.Errorf("node %q: empty tag", node.ID)
+		}
+	}
+	if cfg.Timezone != "" {
+		if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+			return err
+		}
+	}
+	notifiers := make(map[string]struct{}, len(cfg.Notifiers))
+	for _, n := range cfg.Notifiers {
+		if _, err := notify.New(n); err != nil {
+			return err
+		}
+		notifiers[n.Name] = struct{}{}
+	}
+	// a misspelled channel would silently drop the alerts of a rule
+	for _, rule := range cfg.Alerts {
+		for _, name := range rule.Channels {
+			if _, ok := notifiers[name]; !ok {
+				return fmt.Errorf("alert rule %q: unknown channel %q", rule.Name, name)
+			}
+		}
+	}
+	users := make(map[string]struct{}, len(cfg.Auth.Users))
+	for _, user := range cfg.Auth.Users {
+		if user.Username == "" {
+			return errors.New("auth user name is empty")
+		}
+		if _, ok := users[user.Username]; ok {
+			return fmt.Errorf("duplicate auth user %q", user.Username)
+		}
+		users[user.Username] = struct{}{}
+		if _, err := bcrypt.Cost([]byte(user.Password)); err != nil {
+			return fmt.Errorf("auth user %q: password must be a bcrypt hash: %w", user.Username, err)
+		}
+	}
+	if cfg.Auth.SessionTTL != "" {
+		if _, err := time.ParseDuration(cfg.Auth.SessionTTL); err != nil {
+			return fmt.Errorf("auth session ttl: %w", err)
+		}
+	}
+	if cfg.Auth.Private && len(cfg.Auth.Users) == 0 && len(cfg.Auth.Tokens) == 0 {
+		return errors.New("private dashboard needs auth users or tokens")
+	}
+	return nil
+}
+
+// SaveConfig writes cfg to the config file, the file is replaced at once so
+// the watcher never reads it half written.
+func SaveConfig(configFile string, cfg *define.ServerConfig) error {
+	bConf, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := configFile + ".tmp"
+	if err = os.WriteFile(tmp, bConf, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, configFile)
+}
+
+// configLock serializes every load, validation and apply of the config, so
+// a reload of the file and an admin change never apply out of order.
+var configLock sync.Mutex
+
+// ApplyConfig swaps in a validated config, handlers see either the old or
+// the new config but never a mix of both. It is called with configLock
+// held once reloads may run.
+func ApplyConfig(cfg *define.ServerConfig) error {
+	loc := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return err
+		}
+	}
+	if err := notify.Load(cfg.Notifiers); err != nil {
+		return err
+	}
+	cfg.Location = loc
+	vars.Config.Store(cfg)
+	alert.Prune(cfg)
+	return nil
+}
+
+// ReloadConfig loads the config file again and applies it when valid, an
+// invalid file keeps the running config.
+func ReloadConfig(configFile string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	old := vars.Config.Load()
+	if err = ApplyConfig(&cfg); err != nil {
+		return err
+	}
+	logConfigDiff(old, &cfg)
+	return nil
+}
+
+// logConfigDiff logs the nodes added, removed and changed by a reload.
+func logConfigDiff(old, cfg *define.ServerConfig) {
+	oldNodes := make(map[string]define.ServerNode, len(old.Nodes))
+	for _, node := range old.Nodes {
+		oldNodes[node.ID] = node
+	}
+	var added, changed []string
+	for _, node := range cfg.Nodes {
+		oldNode, ok := oldNodes[node.ID]
+		delete(oldNodes, node.ID)
+		if !ok {
+			added = append(added, node.ID)
+			continue
+		}
+		if fields := changedFields(oldNode, node); len(fields) > 0 {
+			changed = append(changed, node.ID+"("+strings.Join(fields, ",")+")")
+		}
+	}
+	removed := make([]string, 0, len(oldNodes))
+	for id := range oldNodes {
+		removed = append(removed, id)
+	}
+	sort.Strings(removed)
+	slog.Info("Config reloaded",
+		slog.Any("added", added),
+		slog.Any("removed", removed),
+		slog.Any("changed", changed),
+		slog.Int("alerts", len(cfg.Alerts)),
+		slog.Int("notifiers", len(cfg.Notifiers)),
+	)
+}
+
+// changedFields returns the json names of the fields that differ between
+// two nodes, values are left out since they may hold tokens.
+func changedFields(a, b define.ServerNode) []string {
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(va.Type().Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// watchConfig reloads the config file when its modification time or size
+// changes, polling keeps it working on every platform and for editors
+// replacing the file.
+func watchConfig(configFile string, interval time.Duration, stop <-chan struct{}) {
+	last, _ := os.Stat(configFile)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(configFile)
+		if err != nil {
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		if err = ReloadConfig(configFile); err != nil {
+			slog.Error("Reload config", slog.String("err", err.Error()))
+		}
+	}
+}
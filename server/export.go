@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/record"
+)
+
+// handleExport streams the raw records of the selected nodes, every
+// visible node when ids is empty, as CSV or newline delimited JSON.
+func handleExport(c *fiber.Ctx) error {
+	now := time.Now().Unix()
+	endTime := int64(c.QueryInt("end"))
+	if endTime == 0 {
+		endTime = now
+	}
+	startTime := int64(c.QueryInt("start"))
+	if startTime == 0 {
+		startTime = endTime - 86400
+	}
+	// node ids come from the config, the query buffer is reused while the
+	// body streams
+	cfg := vars.Config.Load()
+	var nodes []string
+	if q := c.Query("ids"); q != "" {
+		for _, id := range strings.Split(q, ",") {
+			node, ok := configNode(cfg, strings.TrimSpace(id))
+			if !ok || !canView(c, node) {
+				return errNodeNotFound
+			}
+			nodes = append(nodes, node.ID)
+		}
+	} else {
+		for _, node := range cfg.Nodes {
+			if canView(c, node) {
+				nodes = append(nodes, node.ID)
+			}
+		}
+	}
+	var columns []string
+	if q := c.Query("columns"); q != "" {
+		columns = strings.Split(q, ",")
+	}
+	format := c.Query("format", record.ExportCSV)
+	exporter, err := record.NewExporter(record.ExportQuery{
+		Nodes:   nodes,
+		Start:   startTime,
+		End:     endTime,
+		Columns: columns,
+		Format:  format,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	c.Set(fiber.HeaderContentType, exporter.ContentType())
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="cloudstatus-%d-%d.%s"`, startTime, endTime, format))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// the status is sent already, a failure can only cut the body short
+		if err := exporter.Write(w); err != nil {
+			slog.Error("Export records", slog.String("err", err.Error()))
+		}
+	})
+	return nil
+}
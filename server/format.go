@@ -1,5 +1,7 @@
 package server
 
+import "github.com/zjyl1994/cloudstatus/infra/define"
+
 // formatFloat 将浮点数格式化为保留两位小数
 func formatFloat(value float64) float64 {
 	return float64(int64(value*100)) / 100
@@ -12,4 +14,24 @@ func formatFloatMap(data map[string]float64) map[string]float64 {
 		result[k] = formatFloat(v)
 	}
 	return result
-}
\ No newline at end of file
+}
+
+// formatCPUStat 将CPU时间占比格式化为保留两位小数
+func formatCPUStat(stat define.CPUStat) define.CPUStat {
+	stat.User = formatFloat(stat.User)
+	stat.System = formatFloat(stat.System)
+	stat.Nice = formatFloat(stat.Nice)
+	stat.Iowait = formatFloat(stat.Iowait)
+	stat.Irq = formatFloat(stat.Irq)
+	stat.Softirq = formatFloat(stat.Softirq)
+	stat.Steal = formatFloat(stat.Steal)
+	stat.Idle = formatFloat(stat.Idle)
+	if stat.Cores != nil {
+		cores := make([]float64, len(stat.Cores))
+		for i, v := range stat.Cores {
+			cores[i] = formatFloat(v)
+		}
+		stat.Cores = cores
+	}
+	return stat
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/record"
+)
+
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+type metricLabel struct {
+	Name  string
+	Value string
+}
+
+type metricFamily struct {
+	name    string
+	kind    string
+	help    string
+	samples []string
+}
+
+// metricsWriter collects samples grouped by family in insertion order.
+type metricsWriter struct {
+	families []*metricFamily
+	index    map[string]*metricFamily
+}
+
+// add adds a gauge sample.
+func (w *metricsWriter) add(name, help string, labels []metricLabel, value float64) {
+	w.addSample("gauge", name, name, help, labels, value)
+}
+
+// addCounter adds a counter sample, name is the family without the _total
+// suffix of its samples.
+func (w *metricsWriter) addCounter(name, help string, labels []metricLabel, value float64) {
+	w.addSample("counter", name, name+"_total", help, labels, value)
+}
+
+func (w *metricsWriter) addSample(kind, name, sampleName, help string, labels []metricLabel, value float64) {
+	if w.index == nil {
+		w.index = make(map[string]*metricFamily)
+	}
+	family, ok := w.index[name]
+	if !ok {
+		family = &metricFamily{name: name, kind: kind, help: help}
+		w.index[name] = family
+		w.families = append(w.families, family)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(sampleName)
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(l.Name)
+			sb.WriteString(`="`)
+			sb.WriteString(escapeLabelValue(l.Value))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	family.samples = append(family.samples, sb.String())
+}
+
+func (w *metricsWriter) String() string {
+	var sb strings.Builder
+	for _, family := range w.families {
+		sb.WriteString("# TYPE " + family.name + " " + family.kind + "\n")
+		sb.WriteString("# HELP " + family.name + " " + family.help + "\n")
+		for _, sample := range family.samples {
+			sb.WriteString(sample)
+			sb.WriteByte('\n')
+		}
+	}
+	sb.WriteString("# EOF\n")
+	return sb.String()
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func withLabel(labels []metricLabel, name, value string) []metricLabel {
+	result := make([]metricLabel, 0, len(labels)+1)
+	result = append(result, labels...)
+	return append(result, metricLabel{Name: name, Value: value})
+}
+
+func handleMetrics(c *fiber.Ctx) error {
+	traffic, err := record.GetNetTraffic()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	tm := make(map[string]define.TrafficCalcResult, len(traffic))
+	for _, t := range traffic {
+		tm[t.NodeId] = t
+	}
+
+	var w metricsWriter
+	now := time.Now().Unix()
+	for _, node := range vars.Config.Load().Nodes {
+		if !canView(c, node) {
+			continue
+		}
+		labels := []metricLabel{
+			{Name: "id", Value: node.ID},
+			{Name: "label", Value: node.Label},
+			{Name: "location", Value: node.Location},
+		}
+		stat, ok := statCache.Get(node.ID)
+		alive := ok && (now-stat.ReportTime) < int64(vars.NodeAliveTimeout)
+		w.add("cloudstatus_node_alive", "Whether the node reported within the alive timeout.", labels, boolValue(alive))
+		if td, ok := tm[node.ID]; ok {
+			w.add("cloudstatus_network_cycle_transmit_bytes", "Bytes sent in the current traffic cycle.", labels, float64(td.NetSend))
+			w.add("cloudstatus_network_cycle_receive_bytes", "Bytes received in the current traffic cycle.", labels, float64(td.NetRecv))
+		}
+		if !ok {
+			continue
+		}
+
+		w.add("cloudstatus_node_host", "Host information of the node, always 1.", append(labels,
+			metricLabel{Name: "hostname", Value: stat.Host.Hostname},
+			metricLabel{Name: "platform", Value: stat.Host.Platform},
+			metricLabel{Name: "version", Value: stat.Host.Version},
+			metricLabel{Name: "arch", Value: stat.Host.Arch},
+		), 1)
+		w.add("cloudstatus_report_timestamp_seconds", "Time of the latest report.", labels, float64(stat.ReportTime))
+		w.add("cloudstatus_report_interval_seconds", "Report interval of the agent.", labels, float64(stat.Interval))
+		w.add("cloudstatus_uptime_seconds", "Host uptime.", labels, float64(stat.Host.Uptime))
+
+		w.add("cloudstatus_cpu_percent", "CPU usage percent.", labels, stat.Percent.CPU)
+		for _, mode := range []struct {
+			name  string
+			value float64
+		}{
+			{"user", stat.CPU.User},
+			{"system", stat.CPU.System},
+			{"nice", stat.CPU.Nice},
+			{"iowait", stat.CPU.Iowait},
+			{"irq", stat.CPU.Irq},
+			{"softirq", stat.CPU.Softirq},
+			{"steal", stat.CPU.Steal},
+			{"idle", stat.CPU.Idle},
+		} {
+			w.add("cloudstatus_cpu_mode_percent", "Share of CPU time by mode.", withLabel(labels, "mode", mode.name), mode.value)
+		}
+		for i, value := range stat.CPU.Cores {
+			w.add("cloudstatus_cpu_core_percent", "Usage percent of each CPU core.", withLabel(labels, "core", strconv.Itoa(i)), value)
+		}
+		w.add("cloudstatus_memory_percent", "Memory usage percent.", labels, stat.Percent.Mem)
+		w.add("cloudstatus_swap_percent", "Swap usage percent.", labels, stat.Percent.Swap)
+		w.add("cloudstatus_disk_percent", "Disk usage percent.", labels, stat.Percent.Disk)
+
+		w.add("cloudstatus_load1", "1 minute load average.", labels, stat.Load.Load1)
+		w.add("cloudstatus_load5", "5 minute load average.", labels, stat.Load.Load5)
+		w.add("cloudstatus_load15", "15 minute load average.", labels, stat.Load.Load15)
+
+		addUsage(&w, "memory", labels, stat.Memory)
+		addUsage(&w, "swap", labels, stat.Swap)
+		addUsage(&w, "disk", labels, stat.Disk.UsageStat)
+
+		w.add("cloudstatus_disk_read_bytes_per_second", "Disk read speed.", labels, float64(stat.Disk.Rx))
+		w.add("cloudstatus_disk_write_bytes_per_second", "Disk write speed.", labels, float64(stat.Disk.Wx))
+		w.add("cloudstatus_network_receive_bytes_per_second", "Network receive speed.", labels, float64(stat.Network.Rx))
+		w.add("cloudstatus_network_transmit_bytes_per_second", "Network transmit speed.", labels, float64(stat.Network.Tx))
+		w.add("cloudstatus_network_interval_transmit_bytes", "Bytes sent in the latest report interval.", labels, float64(stat.Network.Send))
+		w.add("cloudstatus_network_interval_receive_bytes", "Bytes received in the latest report interval.", labels, float64(stat.Network.Recv))
+
+		for _, v := range stat.Network.Interfaces {
+			ifaceLabels := withLabel(labels, "interface", v.Name)
+			w.add("cloudstatus_interface_receive_bytes_per_second", "Network receive speed by interface.", ifaceLabels, float64(v.Rx))
+			w.add("cloudstatus_interface_transmit_bytes_per_second", "Network transmit speed by interface.", ifaceLabels, float64(v.Tx))
+			w.add("cloudstatus_interface_interval_receive_packets", "Packets received in the latest report interval.", ifaceLabels, float64(v.PacketsRecv))
+			w.add("cloudstatus_interface_interval_transmit_packets", "Packets sent in the latest report interval.", ifaceLabels, float64(v.PacketsSent))
+			w.add("cloudstatus_interface_interval_receive_errors", "Receive errors in the latest report interval.", ifaceLabels, float64(v.ErrIn))
+			w.add("cloudstatus_interface_interval_transmit_errors", "Transmit errors in the latest report interval.", ifaceLabels, float64(v.ErrOut))
+			w.add("cloudstatus_interface_interval_receive_drops", "Dropped incoming packets in the latest report interval.", ifaceLabels, float64(v.DropIn))
+			w.add("cloudstatus_interface_interval_transmit_drops", "Dropped outgoing packets in the latest report interval.", ifaceLabels, float64(v.DropOut))
+		}
+
+		for _, m := range stat.Disk.Mounts {
+			mountLabels := append(withLabel(labels, "mountpoint", m.Path), metricLabel{Name: "fstype", Value: m.Fstype})
+			w.add("cloudstatus_mount_total_bytes", "Total size of the filesystem.", mountLabels, float64(m.Total))
+			w.add("cloudstatus_mount_used_bytes", "Used size of the filesystem.", mountLabels, float64(m.Used))
+			w.add("cloudstatus_mount_percent", "Usage percent of the filesystem.", mountLabels, m.Percent)
+			w.add("cloudstatus_mount_inodes", "Total inodes of the filesystem.", mountLabels, float64(m.InodesTotal))
+			w.add("cloudstatus_mount_inodes_used", "Used inodes of the filesystem.", mountLabels, float64(m.InodesUsed))
+			w.add("cloudstatus_mount_inodes_percent", "Inode usage percent of the filesystem.", mountLabels, m.InodesPercent)
+		}
+
+		sensors := make([]string, 0, len(stat.Temperature))
+		for sensor := range stat.Temperature {
+			sensors = append(sensors, sensor)
+		}
+		sort.Strings(sensors)
+		for _, sensor := range sensors {
+			w.add("cloudstatus_temperature_celsius", "Temperature by sensor.", withLabel(labels, "sensor", sensor), stat.Temperature[sensor])
+		}
+		for _, r := range stat.Sensors {
+			if family, ok := sensorFamilies[r.Type]; ok {
+				w.add(family.name, family.help, withLabel(labels, "sensor", r.Name), r.Value)
+			}
+			if r.Type != define.SensorTemperature {
+				continue
+			}
+			if r.Max > 0 {
+				w.add("cloudstatus_temperature_max_celsius", "Max temperature threshold by sensor.", withLabel(labels, "sensor", r.Name), r.Max)
+			}
+			if r.Crit > 0 {
+				w.add("cloudstatus_temperature_critical_celsius", "Critical temperature threshold by sensor.", withLabel(labels, "sensor", r.Name), r.Crit)
+			}
+		}
+	}
+
+	addWriterStat(&w, record.GetWriterStat())
+
+	c.Set(fiber.HeaderContentType, openMetricsContentType)
+	return c.SendString(w.String())
+}
+
+// addWriterStat reports the record write queue of the server itself.
+func addWriterStat(w *metricsWriter, stat record.WriterStat) {
+	if !stat.Running {
+		return
+	}
+	w.add("cloudstatus_write_queue_depth", "Records waiting in the write queue.", nil, float64(stat.Depth))
+	w.add("cloudstatus_write_queue_capacity", "Size of the write queue.", nil, float64(stat.Capacity))
+	w.add("cloudstatus_write_last_flush_seconds", "Duration of the latest write queue flush.", nil, stat.LastFlush.Seconds())
+	w.add("cloudstatus_write_last_flush_records", "Records written by the latest flush.", nil, float64(stat.LastBatch))
+	w.addCounter("cloudstatus_write_flushes", "Write queue flushes.", nil, float64(stat.Flushes))
+	w.addCounter("cloudstatus_write_retries", "Failed write queue flushes, their records are written again later.", nil, float64(stat.Retries))
+	w.addCounter("cloudstatus_write_records", "Records leaving the write queue by result.", []metricLabel{{Name: "result", Value: "written"}}, float64(stat.Written))
+	w.addCounter("cloudstatus_write_records", "Records leaving the write queue by result.", []metricLabel{{Name: "result", Value: "failed"}}, float64(stat.Failed))
+	w.addCounter("cloudstatus_write_records", "Records leaving the write queue by result.", []metricLabel{{Name: "result", Value: "rejected"}}, float64(stat.Rejected))
+}
+
+// sensorFamilies are the metric families of sensor types other than
+// temperature, which is read from the temperature map of older agents too.
+var sensorFamilies = map[string]struct{ name, help string }{
+	define.SensorFan:     {"cloudstatus_fan_rpm", "Fan speed by sensor."},
+	define.SensorVoltage: {"cloudstatus_voltage_volts", "Voltage by sensor."},
+	define.SensorPower:   {"cloudstatus_power_watts", "Power by sensor."},
+}
+
+func addUsage(w *metricsWriter, name string, labels []metricLabel, usage define.UsageStat) {
+	w.add("cloudstatus_"+name+"_total_bytes", "Total "+name+" size.", labels, float64(usage.Total))
+	w.add("cloudstatus_"+name+"_used_bytes", "Used "+name+" size.", labels, float64(usage.Used))
+	w.add("cloudstatus_"+name+"_free_bytes", "Free "+name+" size.", labels, float64(usage.Free))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
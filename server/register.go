@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/register"
+	"gorm.io/gorm"
+)
+
+// handleRegister enrolls an agent presenting the registration token. It
+// stays pending until an admin approves it, then calls with the secret of
+// the enrollment are issued a node token until the node first reports.
+func handleRegister(c *fiber.Ctx) error {
+	cfg := vars.Config.Load()
+	if cfg.RegistrationToken == "" {
+		return c.Status(fiber.StatusForbidden).SendString("Registration disabled")
+	}
+	if !matchToken(cfg.RegistrationToken, bearerToken(c)) {
+		return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
+	}
+	var req define.RegisterRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if req.NodeID == "" {
+		req.NodeID = req.Hostname
+	}
+	if req.NodeID == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Missing node id")
+	}
+	if req.Secret == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Missing registration secret")
+	}
+
+	pending, err := register.Get(req.NodeID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	switch {
+	case pending.Status == define.PendingStatusApproved:
+		if !register.CheckSecret(pending, req.Secret) {
+			return c.Status(fiber.StatusForbidden).SendString("Registration secret mismatch")
+		}
+		// the approval is stored before the node is added, its token only
+		// works once the node is in the config
+		if _, ok := configNode(cfg, req.NodeID); !ok {
+			return c.Status(fiber.StatusAccepted).JSON(define.RegisterResponse{Status: define.PendingStatusPending})
+		}
+		token, err := issueToken(req.NodeID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(define.RegisterResponse{Status: pending.Status, Token: token})
+	case pending.Status == define.PendingStatusRejected:
+		return c.Status(fiber.StatusForbidden).SendString("Registration rejected")
+	}
+	if _, ok := configNode(cfg, req.NodeID); ok {
+		return c.Status(fiber.StatusConflict).SendString("Node already exists")
+	}
+	if _, err = register.Touch(req, c.IP()); err != nil {
+		if errors.Is(err, register.ErrSecretMismatch) {
+			return c.Status(fiber.StatusForbidden).SendString("Registration secret mismatch")
+		}
+		return err
+	}
+	return c.Status(fiber.StatusAccepted).JSON(define.RegisterResponse{Status: define.PendingStatusPending})
+}
+
+// issueToken gives the node of an approved registration a new token. Only
+// its digest is kept, so every pick up issues another one and a response
+// lost on the way is picked up again.
+func issueToken(nodeId string) (string, error) {
+	token, digest, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	err = updateConfig(func(cfg *define.ServerConfig) error {
+		i := nodeIndex(cfg.Nodes, nodeId)
+		if i < 0 {
+			return errNodeNotFound
+		}
+		cfg.Nodes[i].Token = digest
+		return nil
+	})
+	return token, err
+}
+
+func handleAdminPending(c *fiber.Ctx) error {
+	nodes, err := register.List()
+	if err != nil {
+		return err
+	}
+	return c.JSON(nodes)
+}
+
+// pendingNode loads the registration named by the id param.
+func pendingNode(c *fiber.Ctx) (define.PendingNode, error) {
+	pending, err := register.Get(strings.Clone(c.Params("id")))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pending, errNodeNotFound
+	}
+	return pending, err
+}
+
+// handleAdminApprove adds a pending agent to the nodes, the body may set
+// node fields such as label and location. The agent picks up its token
+// through handleRegister.
+func handleAdminApprove(c *fiber.Ctx) error {
+	pending, err := pendingNode(c)
+	if err != nil {
+		return err
+	}
+	if pending.Status != define.PendingStatusPending {
+		return fiber.NewError(fiber.StatusConflict, "Node is "+pending.Status)
+	}
+	var node define.ServerNode
+	if len(c.Body()) > 0 {
+		if err = c.BodyParser(&node); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+	}
+	node.ID = pending.NodeID
+	if node.Label == "" {
+		node.Label = pending.Hostname
+	}
+	// no token works until the agent picks one up
+	_, node.Token, err = newToken()
+	if err != nil {
+		return err
+	}
+	// approve first, a failed config update can be taken back while the
+	// agent waits for its node to be added
+	if err = register.Approve(node.ID); err != nil {
+		if errors.Is(err, register.ErrNotPending) {
+			return fiber.NewError(fiber.StatusConflict, "Node is no longer pending")
+		}
+		return err
+	}
+	err = updateConfig(func(cfg *define.ServerConfig) error {
+		if nodeIndex(cfg.Nodes, node.ID) >= 0 {
+			return fiber.NewError(fiber.StatusConflict, "Node already exists")
+		}
+		cfg.Nodes = append(cfg.Nodes, node)
+		return nil
+	})
+	if err != nil {
+		if revertErr := register.Revert(node.ID); revertErr != nil {
+			slog.Error("Revert approval", slog.String("node", node.ID), slog.String("err", revertErr.Error()))
+		}
+		return err
+	}
+	return c.JSON(node.Public())
+}
+
+func handleAdminReject(c *fiber.Ctx) error {
+	pending, err := pendingNode(c)
+	if err != nil {
+		return err
+	}
+	if err = register.Reject(pending.NodeID); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func handleAdminDeletePending(c *fiber.Ctx) error {
+	pending, err := pendingNode(c)
+	if err != nil {
+		return err
+	}
+	if err = register.Delete(pending.NodeID); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// forgetRegistration drops the approved registration of a node reporting
+// with its own token, the agent has picked up its token by then.
+func forgetRegistration(nodeId string) {
+	if err := register.Done(nodeId); err != nil {
+		slog.Error("Forget registration", slog.String("node", nodeId), slog.String("err", err.Error()))
+	}
+}
@@ -15,15 +15,43 @@ func Start(listen string) error {
 		DisableStartupMessage: true,
 	})
 	vars.App = app
-	app.Use(cors.New())
+	// origins are checked against the current config so reloads apply, API
+	// tokens instead of cookies are used across origins
+	app.Use(cors.New(cors.Config{AllowOriginsFunc: corsOrigin}))
 
 	apiG := app.Group("/api")
 	{
 		apiG.Post("/report", handleAPIReport)
-		apiG.Get("/overview", handleOverview)
-		apiG.Get("/charts", handleCharts)
-		apiG.Get("/nodes", handleNodes)
+		apiG.Post("/report/batch", handleAPIBatchReport)
+		apiG.Post("/report/register", handleRegister)
+		apiG.Post("/login", handleLogin)
+		apiG.Post("/logout", handleLogout)
+		apiG.Get("/session", handleSession)
+		apiG.Get("/overview", viewerAuth, handleOverview)
+		apiG.Get("/charts", viewerAuth, handleCharts)
+		apiG.Get("/compare", viewerAuth, handleCompare)
+		apiG.Get("/export", viewerAuth, handleExport)
+		apiG.Get("/nodes", viewerAuth, handleNodes)
+		apiG.Get("/traffic", viewerAuth, handleTraffic)
+		apiG.Get("/stream", viewerAuth, handleStream)
+		apiG.Get("/events", viewerAuth, handleEvents)
 	}
+	adminG := apiG.Group("/admin", adminAuth)
+	{
+		adminG.Get("/nodes", handleAdminNodes)
+		adminG.Post("/nodes", handleAdminCreateNode)
+		adminG.Put("/nodes/order", handleAdminOrderNodes)
+		adminG.Put("/nodes/:id", handleAdminUpdateNode)
+		adminG.Delete("/nodes/:id", handleAdminDeleteNode)
+		adminG.Post("/nodes/:id/token", handleAdminRotateNodeToken)
+		adminG.Post("/token", handleAdminRotateToken)
+		adminG.Put("/title", handleAdminTitle)
+		adminG.Get("/pending", handleAdminPending)
+		adminG.Post("/pending/:id/approve", handleAdminApprove)
+		adminG.Post("/pending/:id/reject", handleAdminReject)
+		adminG.Delete("/pending/:id", handleAdminDeletePending)
+	}
+	app.Get("/metrics", viewerAuth, handleMetrics)
 
 	app.Use(filesystem.New(filesystem.Config{
 		Root:         http.FS(cloudstatusfe.FrontendAssets),
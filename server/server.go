@@ -2,23 +2,24 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	slogGorm "github.com/orandin/slog-gorm"
 	"github.com/robfig/cron/v3"
 	"github.com/spf13/cobra"
-	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/storage"
 	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/alert"
+	"github.com/zjyl1994/cloudstatus/service/notify"
 	"github.com/zjyl1994/cloudstatus/service/record"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
+// configWatchInterval is how often the config file is checked for changes.
+const configWatchInterval = 5 * time.Second
+
 func Server(cmd *cobra.Command, args []string) {
 	var err error
 	vars.DebugMode, err = cmd.Flags().GetBool("debug")
@@ -48,55 +49,74 @@ func Server(cmd *cobra.Command, args []string) {
 		slog.Error("Error", slog.String("err", err.Error()))
 		return
 	}
+	vars.ConfigFile = configFile
 
-	bConf, err := os.ReadFile(configFile)
+	cfg, err := LoadConfig(configFile)
 	if err != nil {
 		slog.Error("Load config", slog.String("err", err.Error()))
 		return
 	}
-
-	var cfg define.ServerConfig
-	err = json.Unmarshal(bConf, &cfg)
-	if err != nil {
-		slog.Error("Unmarshal config", slog.String("err", err.Error()))
+	if err = ApplyConfig(&cfg); err != nil {
+		slog.Error("Apply config", slog.String("err", err.Error()))
 		return
 	}
-	vars.Config = cfg
+	alert.Subscribe(notify.Dispatch)
 
-	// init db
-	dbFile, err := cmd.Flags().GetString("db")
+	// init db, flags win over the config file
+	dbDriver, dbDsn := cfg.Database.Driver, cfg.Database.DSN
+	if cmd.Flags().Changed("db-driver") || dbDriver == "" {
+		if dbDriver, err = cmd.Flags().GetString("db-driver"); err != nil {
+			slog.Error("Error", slog.String("err", err.Error()))
+			return
+		}
+	}
+	if cmd.Flags().Changed("db") || dbDsn == "" {
+		if dbDsn, err = cmd.Flags().GetString("db"); err != nil {
+			slog.Error("Error", slog.String("err", err.Error()))
+			return
+		}
+	}
+	vars.DB, err = storage.Open(dbDriver, dbDsn)
 	if err != nil {
-		slog.Error("Error", slog.String("err", err.Error()))
+		slog.Error("Open database", slog.String("driver", dbDriver), slog.String("err", err.Error()))
 		return
 	}
-	gormLogger := slogGorm.New()
-	vars.DB, err = gorm.Open(sqlite.Open(dbFile), &gorm.Config{
-		Logger: gormLogger,
-	})
-	if err != nil {
-		slog.Error("Open database", slog.String("err", err.Error()))
+	if err = record.InitTrafficLedger(); err != nil {
+		slog.Error("Init traffic ledger", slog.String("err", err.Error()))
 		return
 	}
-	err = vars.DB.Exec("PRAGMA journal_mode=WAL;").Error
-	if err != nil {
-		slog.Error("Switch to WAL", slog.String("err", err.Error()))
+	var writerOpts record.WriterOptions
+	if writerOpts.QueueSize, err = cmd.Flags().GetInt("write-queue"); err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
 		return
 	}
-	err = vars.DB.AutoMigrate(&define.MeasureRecord{})
-	if err != nil {
-		slog.Error("Database migrate", slog.String("err", err.Error()))
+	if writerOpts.BatchSize, err = cmd.Flags().GetInt("write-batch"); err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
 		return
 	}
+	if writerOpts.Interval, err = cmd.Flags().GetDuration("write-interval"); err != nil {
+		slog.Error("Error", slog.String("err", err.Error()))
+		return
+	}
+	record.StartWriter(writerOpts)
 	// clean data
 	cleanDataFn := func() {
 		if err = record.CleanRecord(); err != nil {
 			slog.Error("Measure data clean", slog.String("err", err.Error()))
 		}
 	}
+	rollupFn := func() {
+		if err := record.RunRollup(); err != nil {
+			slog.Error("Measure data rollup", slog.String("err", err.Error()))
+		}
+	}
 	cronInstance := cron.New()
 	cronInstance.AddFunc("@daily", cleanDataFn)
+	cronInstance.AddFunc("@every 5m", rollupFn)
+	cronInstance.AddFunc("@every 15s", alert.Check)
 	cronInstance.Start()
 	cleanDataFn()
+	go rollupFn()
 	// run web server
 	webErrCh := make(chan error, 1)
 	go func(ch chan error) {
@@ -105,33 +125,49 @@ func Server(cmd *cobra.Command, args []string) {
 			webErrCh <- err
 		}
 	}(webErrCh)
+	// reload config on change or SIGHUP
+	watchStop := make(chan struct{})
+	defer close(watchStop)
+	go watchConfig(configFile, configWatchInterval, watchStop)
 	// register ctrl+c handler
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	select {
-	case err := <-webErrCh:
-		if err != nil {
-			slog.Error("Web server faild", slog.String("err", err.Error()))
+	for {
+		select {
+		case err := <-webErrCh:
+			if err != nil {
+				slog.Error("Web server faild", slog.String("err", err.Error()))
+			}
+			record.StopWriter()
 			return
-		}
-	case sig := <-sigChan:
-		if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				if err := ReloadConfig(configFile); err != nil {
+					slog.Error("Reload config", slog.String("err", err.Error()))
+				}
+				continue
+			}
+
 			slog.Info("Signal receive", slog.String("singal", sig.String()))
 
 			cronInstance.Stop()
 
 			if vars.App != nil {
+				broker.close()
 				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 				defer cancel()
 				vars.App.ShutdownWithContext(ctx)
 			}
+			// write the queued records before the database goes away
+			record.StopWriter()
 			if vars.DB != nil {
 				rawDB, _ := vars.DB.DB()
 				if rawDB != nil {
 					rawDB.Close()
 				}
 			}
+			return
 		}
 	}
 }
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie     = "cloudstatus_session"
+	defaultSessionTTL = 7 * 24 * time.Hour
+	viewerKey         = "viewer"
+	// tokenViewer is the viewer name of requests using an API token
+	tokenViewer = "token"
+)
+
+// sessionSecret signs sessions when the config has no secret.
+var sessionSecret = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+// dummyHash is compared against for unknown users so a failed login takes
+// the same time whether the user exists or not.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("cloudstatus"), bcrypt.DefaultCost)
+
+type loginReq struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type sessionResp struct {
+	Username string `json:"username"`
+	Token    string `json:"token,omitempty"`
+	Expire   int64  `json:"expire,omitempty"`
+	Auth     bool   `json:"auth"`
+	Private  bool   `json:"private"`
+}
+
+func sessionKey(cfg *define.ServerConfig) []byte {
+	if cfg.Auth.SessionSecret != "" {
+		return []byte(cfg.Auth.SessionSecret)
+	}
+	return sessionSecret
+}
+
+func sessionTTL(cfg *define.ServerConfig) time.Duration {
+	if ttl, err := time.ParseDuration(cfg.Auth.SessionTTL); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultSessionTTL
+}
+
+// userGeneration changes with the password of user, sessions carry it so
+// changing a password ends the sessions signed before.
+func userGeneration(user define.AuthUser) string {
+	sum := sha256.Sum256([]byte(user.Password))
+	return hex.EncodeToString(sum[:8])
+}
+
+// signSession returns a session token "<user>.<generation>.<expire>.<mac>",
+// the user is base64 encoded so it may hold dots.
+func signSession(cfg *define.ServerConfig, user define.AuthUser, expire time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(user.Username)) + "." + userGeneration(user) + "." + strconv.FormatInt(expire.Unix(), 10)
+	mac := hmac.New(sha256.New, sessionKey(cfg))
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseSession returns the user of a valid session token, sessions of users
+// removed from config or whose password changed are no longer valid.
+func parseSession(cfg *define.ServerConfig, token string) (string, bool) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := token[:i], token[i+1:]
+	mac := hmac.New(sha256.New, sessionKey(cfg))
+	mac.Write([]byte(payload))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(sig)) {
+		return "", false
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	encodedUser, generation, expireStr := parts[0], parts[1], parts[2]
+	expire, err := strconv.ParseInt(expireStr, 10, 64)
+	if err != nil || time.Now().Unix() > expire {
+		return "", false
+	}
+	username, err := base64.RawURLEncoding.DecodeString(encodedUser)
+	if err != nil {
+		return "", false
+	}
+	user, ok := findUser(cfg, string(username))
+	if !ok || generation != userGeneration(user) {
+		return "", false
+	}
+	return user.Username, true
+}
+
+func findUser(cfg *define.ServerConfig, username string) (define.AuthUser, bool) {
+	for _, user := range cfg.Auth.Users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return define.AuthUser{}, false
+}
+
+// resolveViewer returns who is making the request from the session cookie or
+// the bearer token, empty for anonymous viewers.
+func resolveViewer(c *fiber.Ctx, cfg *define.ServerConfig) string {
+	if token := c.Cookies(sessionCookie); token != "" {
+		if user, ok := parseSession(cfg, token); ok {
+			return user
+		}
+	}
+	if token := bearerToken(c); token != "" {
+		if user, ok := parseSession(cfg, token); ok {
+			return user
+		}
+		for _, t := range cfg.Auth.Tokens {
+			if matchToken(t, token) {
+				return tokenViewer
+			}
+		}
+	}
+	return ""
+}
+
+// viewerAuth resolves the viewer of read APIs and turns anonymous viewers
+// away from a private dashboard.
+func viewerAuth(c *fiber.Ctx) error {
+	cfg := vars.Config.Load()
+	viewer := resolveViewer(c, cfg)
+	c.Locals(viewerKey, viewer)
+	if cfg.Auth.Private && viewer == "" {
+		return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
+	}
+	return c.Next()
+}
+
+func isViewer(c *fiber.Ctx) bool {
+	viewer, _ := c.Locals(viewerKey).(string)
+	return viewer != ""
+}
+
+// canView reports whether the request may see node.
+func canView(c *fiber.Ctx, node define.ServerNode) bool {
+	return !node.Private || isViewer(c)
+}
+
+// checkNodeView rejects requests for a private node the viewer may not see,
+// as if the node did not exist.
+func checkNodeView(c *fiber.Ctx, nodeId string) error {
+	if node, ok := findNode(nodeId); ok && !canView(c, node) {
+		return errNodeNotFound
+	}
+	return nil
+}
+
+func handleLogin(c *fiber.Ctx) error {
+	var req loginReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	cfg := vars.Config.Load()
+	user, ok := findUser(cfg, req.Username)
+	hash := []byte(user.Password)
+	if !ok {
+		hash = dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || !ok {
+		return c.Status(fiber.StatusUnauthorized).SendString("Invalid username or password")
+	}
+
+	expire := time.Now().Add(sessionTTL(cfg))
+	token := signSession(cfg, user, expire)
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expire,
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.JSON(sessionResp{
+		Username: user.Username,
+		Token:    token,
+		Expire:   expire.Unix(),
+		Auth:     true,
+		Private:  cfg.Auth.Private,
+	})
+}
+
+// handleLogout ends the cookie session. Session tokens used as bearer tokens
+// are not stored on the server and cannot be logged out, they stay valid
+// until they expire, the password of their user changes or the session
+// secret changes.
+func handleLogout(c *fiber.Ctx) error {
+	c.ClearCookie(sessionCookie)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleSession tells the dashboard who is logged in and whether it has to
+// show a login.
+func handleSession(c *fiber.Ctx) error {
+	cfg := vars.Config.Load()
+	return c.JSON(sessionResp{
+		Username: resolveViewer(c, cfg),
+		Auth:     len(cfg.Auth.Users) > 0 || len(cfg.Auth.Tokens) > 0,
+		Private:  cfg.Auth.Private,
+	})
+}
+
+// corsOrigin allows the origins listed in config, every origin when the
+// list is empty or holds "*".
+func corsOrigin(origin string) bool {
+	origins := vars.Config.Load().CorsOrigins
+	if len(origins) == 0 {
+		return true
+	}
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/service/record"
+)
+
+const (
+	streamHistorySize  = 1024
+	streamChannelSize  = 64
+	streamHeartbeat    = 15 * time.Second
+	streamRetryMillis  = 3000
+	streamEventNode    = "node"
+	streamEventReset   = "reset"
+	streamEventPayload = "{}"
+)
+
+type streamEvent struct {
+	ID      uint64
+	NodeID  string
+	Private bool
+	Data    []byte
+}
+
+type streamSubscriber struct {
+	nodes map[string]struct{}
+	// viewer is set for logged in subscribers, who see private nodes
+	viewer bool
+	ch     chan streamEvent
+}
+
+func (s *streamSubscriber) match(ev streamEvent) bool {
+	if ev.Private && !s.viewer {
+		return false
+	}
+	if s.nodes == nil {
+		return true
+	}
+	_, ok := s.nodes[ev.NodeID]
+	return ok
+}
+
+// streamBroker fans out node updates to SSE subscribers and keeps a short
+// history so reconnecting clients can resume from Last-Event-ID.
+type streamBroker struct {
+	lock        sync.Mutex
+	lastID      uint64
+	history     []streamEvent
+	subscribers map[*streamSubscriber]struct{}
+}
+
+// event ids start from the boot time so they keep growing across restarts
+var broker = &streamBroker{
+	lastID:      uint64(time.Now().UnixMilli()),
+	subscribers: make(map[*streamSubscriber]struct{}),
+}
+
+func (b *streamBroker) publish(nodeId string, private bool, data []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lastID++
+	ev := streamEvent{ID: b.lastID, NodeID: nodeId, Private: private, Data: data}
+	if len(b.history) >= streamHistorySize {
+		b.history = b.history[1:]
+	}
+	b.history = append(b.history, ev)
+
+	for sub := range b.subscribers {
+		if !sub.match(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// too slow, drop it and let the client resume after reconnect
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber and returns the events after lastID.
+// reset is true when lastID is older than the kept history.
+func (b *streamBroker) subscribe(nodes map[string]struct{}, viewer bool, lastID uint64) (sub *streamSubscriber, backlog []streamEvent, reset bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sub = &streamSubscriber{nodes: nodes, viewer: viewer, ch: make(chan streamEvent, streamChannelSize)}
+	b.subscribers[sub] = struct{}{}
+	if lastID == 0 || lastID >= b.lastID {
+		return sub, nil, false
+	}
+	if len(b.history) == 0 || b.history[0].ID > lastID+1 {
+		reset = true
+	}
+	for _, ev := range b.history {
+		if ev.ID > lastID && sub.match(ev) {
+			backlog = append(backlog, ev)
+		}
+	}
+	return sub, backlog, reset
+}
+
+func (b *streamBroker) unsubscribe(sub *streamSubscriber) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// close ends every open stream.
+func (b *streamBroker) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// publishStat pushes an accepted report of a configured node to subscribers.
+func publishStat(stat define.StatExchangeFormat) {
+	node, ok := findNode(stat.NodeID)
+	if !ok {
+		return
+	}
+	td, err := record.GetNodeTraffic(node.ID)
+	if err != nil {
+		slog.Error("Stream load traffic", slog.String("err", err.Error()))
+	}
+	data, err := json.Marshal(decorateStat(node, stat, td))
+	if err != nil {
+		slog.Error("Stream marshal", slog.String("err", err.Error()))
+		return
+	}
+	broker.publish(node.ID, node.Private, data)
+}
+
+func handleStream(c *fiber.Ctx) error {
+	var nodes map[string]struct{}
+	if q := c.Query("nodes"); q != "" {
+		nodes = make(map[string]struct{})
+		for _, id := range strings.Split(q, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				// the query points into the request buffer, which fiber
+				// reuses while the stream goes on
+				nodes[strings.Clone(id)] = struct{}{}
+			}
+		}
+	}
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	sub, backlog, reset := broker.subscribe(nodes, isViewer(c), lastID)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer broker.unsubscribe(sub)
+
+		fmt.Fprintf(w, "retry: %d\n\n", streamRetryMillis)
+		if reset {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", streamEventReset, streamEventPayload)
+		}
+		for _, ev := range backlog {
+			writeStreamEvent(w, ev)
+		}
+		if w.Flush() != nil {
+			return
+		}
+
+		ticker := time.NewTicker(streamHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case ev, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				writeStreamEvent(w, ev)
+			case <-ticker.C:
+				fmt.Fprintf(w, ": heartbeat %d\n\n", time.Now().Unix())
+			}
+			if w.Flush() != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func writeStreamEvent(w *bufio.Writer, ev streamEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, streamEventNode, ev.Data)
+}
@@ -0,0 +1,358 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"github.com/zjyl1994/cloudstatus/service/record"
+)
+
+const (
+	MetricOffline          = "offline"
+	MetricTraffic          = "traffic_percent"
+	MetricTrafficProjected = "traffic_projected_percent"
+)
+
+var metrics = []string{
+	"cpu", "cpu_steal", "cpu_iowait", "mem", "swap", "disk", "load1", "load5", "load15",
+	"mount", "mount_inodes", "net_rx", "net_tx", "temperature", "fan", "voltage", "power",
+	MetricOffline, MetricTraffic, MetricTrafficProjected,
+}
+
+type ruleState struct {
+	pendingSince time.Time
+	firing       bool
+}
+
+var (
+	states    = make(map[string]*ruleState)
+	lastSeen  = make(map[string]time.Time)
+	startAt   = time.Now()
+	lock      sync.Mutex
+	listeners []func(define.AlertEvent)
+)
+
+// Subscribe registers fn to receive firing and resolved events.
+func Subscribe(fn func(define.AlertEvent)) {
+	lock.Lock()
+	defer lock.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Validate checks alert rules loaded from config.
+func Validate(rules []define.AlertRule) error {
+	names := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return errors.New("alert rule name is empty")
+		}
+		if _, ok := names[rule.Name]; ok {
+			return fmt.Errorf("duplicate alert rule %q", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		if !slices.Contains(metrics, rule.Metric) {
+			return fmt.Errorf("alert rule %q: unknown metric %q", rule.Name, rule.Metric)
+		}
+		if _, err := compare(rule.Operator, 0, 0); err != nil {
+			return fmt.Errorf("alert rule %q: %w", rule.Name, err)
+		}
+		if _, err := ruleDuration(rule); err != nil {
+			return fmt.Errorf("alert rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// Prune forgets the state of rules and nodes no longer in cfg, so a rule
+// removed while firing does not linger.
+func Prune(cfg *define.ServerConfig) {
+	keep := make(map[string]struct{})
+	nodes := make(map[string]struct{}, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		nodes[node.ID] = struct{}{}
+		for _, rule := range cfg.Alerts {
+			keep[node.ID+"\x00"+rule.Name] = struct{}{}
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for key := range states {
+		if _, ok := keep[key]; !ok {
+			delete(states, key)
+		}
+	}
+	for id := range lastSeen {
+		if _, ok := nodes[id]; !ok {
+			delete(lastSeen, id)
+		}
+	}
+}
+
+// Evaluate checks every rule against a report accepted from a node.
+func Evaluate(stat *define.StatExchangeFormat) {
+	cfg := vars.Config.Load()
+	node, ok := findNode(cfg, stat.NodeID)
+	if !ok {
+		return
+	}
+	now := time.Now()
+
+	lock.Lock()
+	defer lock.Unlock()
+	lastSeen[node.ID] = now
+	for _, rule := range cfg.Alerts {
+		if !matchNode(rule, node.ID) {
+			continue
+		}
+		switch {
+		case rule.Metric == MetricOffline:
+			update(rule, node, 0, now)
+		case periodicMetric(rule.Metric):
+			// evaluated by Check
+		default:
+			if value, ok := metricValue(rule, stat); ok {
+				update(rule, node, value, now)
+			}
+		}
+	}
+}
+
+// Check evaluates offline and traffic quota rules, it should be called
+// periodically.
+func Check() {
+	now := time.Now()
+	traffic, err := record.GetNetTraffic()
+	if err != nil {
+		slog.Error("Alert load traffic", slog.String("err", err.Error()))
+	}
+	tm := make(map[string]define.TrafficCalcResult, len(traffic))
+	for _, t := range traffic {
+		tm[t.NodeId] = t
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	cfg := vars.Config.Load()
+	for _, rule := range cfg.Alerts {
+		if !periodicMetric(rule.Metric) {
+			continue
+		}
+		for _, node := range cfg.Nodes {
+			if !matchNode(rule, node.ID) {
+				continue
+			}
+			switch rule.Metric {
+			case MetricOffline:
+				seen, ok := lastSeen[node.ID]
+				if !ok {
+					seen = startAt
+				}
+				update(rule, node, now.Sub(seen).Seconds(), now)
+			case MetricTraffic, MetricTrafficProjected:
+				if err != nil {
+					continue
+				}
+				td := tm[node.ID]
+				quota := record.CalcQuota(node, td.NetSend, td.NetRecv, now)
+				if quota == nil {
+					continue
+				}
+				value := quota.Percent
+				if rule.Metric == MetricTrafficProjected {
+					value = quota.ProjectedPercent
+				}
+				update(rule, node, value, now)
+			}
+		}
+	}
+}
+
+func periodicMetric(metric string) bool {
+	return metric == MetricOffline || metric == MetricTraffic || metric == MetricTrafficProjected
+}
+
+// update moves the state machine of one node and rule, lock must be held.
+func update(rule define.AlertRule, node define.ServerNode, value float64, now time.Time) {
+	key := node.ID + "\x00" + rule.Name
+	state, ok := states[key]
+	if !ok {
+		state = new(ruleState)
+		states[key] = state
+	}
+
+	threshold := rule.Threshold
+	if rule.Metric == MetricOffline && threshold == 0 {
+		threshold = float64(vars.NodeAliveTimeout)
+	}
+	matched, _ := compare(rule.Operator, value, threshold)
+	if !matched {
+		state.pendingSince = time.Time{}
+		if state.firing {
+			state.firing = false
+			dispatch(rule, node, define.AlertResolved, value, now)
+		}
+		return
+	}
+
+	if state.pendingSince.IsZero() {
+		state.pendingSince = now
+	}
+	duration, _ := ruleDuration(rule)
+	if !state.firing && now.Sub(state.pendingSince) >= duration {
+		state.firing = true
+		dispatch(rule, node, define.AlertFiring, value, state.pendingSince)
+	}
+}
+
+func dispatch(rule define.AlertRule, node define.ServerNode, state string, value float64, since time.Time) {
+	event := define.AlertEvent{
+		Rule:  rule,
+		Node:  node.Public(),
+		State: state,
+		Value: value,
+		Since: since,
+		Time:  time.Now(),
+	}
+	slog.Info("Alert",
+		slog.String("rule", rule.Name),
+		slog.String("node", node.ID),
+		slog.String("state", state),
+		slog.Float64("value", value))
+	for _, fn := range listeners {
+		go fn(event)
+	}
+}
+
+func metricValue(rule define.AlertRule, stat *define.StatExchangeFormat) (float64, bool) {
+	switch rule.Metric {
+	case "cpu":
+		return stat.Percent.CPU, true
+	case "cpu_steal":
+		return stat.CPU.Steal, true
+	case "cpu_iowait":
+		return stat.CPU.Iowait, true
+	case "mem":
+		return stat.Percent.Mem, true
+	case "swap":
+		return stat.Percent.Swap, true
+	case "disk":
+		return stat.Percent.Disk, true
+	case "load1":
+		return stat.Load.Load1, true
+	case "load5":
+		return stat.Load.Load5, true
+	case "load15":
+		return stat.Load.Load15, true
+	case "mount", "mount_inodes":
+		var value float64
+		var found bool
+		for _, m := range stat.Disk.Mounts {
+			if rule.Mount != "" && m.Path != rule.Mount {
+				continue
+			}
+			percent := m.Percent
+			if rule.Metric == "mount_inodes" {
+				percent = m.InodesPercent
+			}
+			// without a mount path the fullest mount is used
+			value = max(value, percent)
+			found = true
+		}
+		return value, found
+	case "net_rx":
+		return float64(stat.Network.Rx), true
+	case "net_tx":
+		return float64(stat.Network.Tx), true
+	case "temperature":
+		if rule.Sensor != "" {
+			value, ok := stat.Temperature[rule.Sensor]
+			return value, ok
+		}
+		var maxValue float64
+		for _, value := range stat.Temperature {
+			maxValue = max(maxValue, value)
+		}
+		return maxValue, len(stat.Temperature) > 0
+	case "fan", "voltage", "power":
+		return sensorValue(rule, stat.Sensors)
+	}
+	return 0, false
+}
+
+// sensorValue returns the reading of the rule sensor. Without a sensor name
+// the worst reading of the type is used, the lowest one for < rules since
+// those watch for stalled fans or sagging voltages.
+func sensorValue(rule define.AlertRule, readings []define.SensorStat) (float64, bool) {
+	var value float64
+	var found bool
+	low := rule.Operator == "<" || rule.Operator == "<="
+	for _, r := range readings {
+		if r.Type != rule.Metric {
+			continue
+		}
+		if rule.Sensor != "" {
+			if r.Name == rule.Sensor {
+				return r.Value, true
+			}
+			continue
+		}
+		switch {
+		case !found:
+			value = r.Value
+		case low:
+			value = min(value, r.Value)
+		default:
+			value = max(value, r.Value)
+		}
+		found = true
+	}
+	return value, found
+}
+
+func compare(operator string, value, threshold float64) (bool, error) {
+	switch operator {
+	case ">", "":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", operator)
+}
+
+func ruleDuration(rule define.AlertRule) (time.Duration, error) {
+	if rule.For == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(rule.For)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", rule.For)
+	}
+	return d, nil
+}
+
+func matchNode(rule define.AlertRule, nodeId string) bool {
+	return len(rule.Nodes) == 0 || slices.Contains(rule.Nodes, nodeId)
+}
+
+func findNode(cfg *define.ServerConfig, id string) (define.ServerNode, bool) {
+	for _, node := range cfg.Nodes {
+		if node.ID == id {
+			return node, true
+		}
+	}
+	return define.ServerNode{}, false
+}
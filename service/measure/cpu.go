@@ -0,0 +1,81 @@
+package measure
+
+import (
+	"math"
+	"runtime"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+func init() {
+	Register("cpu", true, func(Options) Collector { return new(cpuCollector) })
+}
+
+// cpuCollector computes CPU usage, the time breakdown by mode and per core
+// usage from the CPU times at both ends of the measure interval.
+type cpuCollector struct {
+	start      []cpu.TimesStat
+	startCores []cpu.TimesStat
+}
+
+func (c *cpuCollector) Name() string { return "cpu" }
+
+func (c *cpuCollector) Prepare() error {
+	// usage is best effort, an unreadable cpu leaves it zero
+	c.start, _ = cpu.Times(false)
+	c.startCores, _ = cpu.Times(true)
+	return nil
+}
+
+func (c *cpuCollector) Collect(s *Sample) error {
+	if end, err := cpu.Times(false); err == nil && len(end) > 0 && len(c.start) > 0 {
+		s.Result.Percent.CPU = busyPercent(c.start[0], end[0])
+		s.Result.CPU = modePercent(c.start[0], end[0])
+	}
+	if end, err := cpu.Times(true); err == nil && len(end) == len(c.startCores) {
+		s.Result.CPU.Cores = make([]float64, len(end))
+		for i := range end {
+			s.Result.CPU.Cores[i] = busyPercent(c.startCores[i], end[i])
+		}
+	}
+	return nil
+}
+
+func cpuTotal(t cpu.TimesStat) float64 {
+	total := t.Total()
+	if runtime.GOOS == "linux" {
+		// guest time is already counted in user time
+		total -= t.Guest + t.GuestNice
+	}
+	return total
+}
+
+func busyPercent(t1, t2 cpu.TimesStat) float64 {
+	total := cpuTotal(t2) - cpuTotal(t1)
+	if total <= 0 {
+		return 0
+	}
+	busy := total - (t2.Idle - t1.Idle) - (t2.Iowait - t1.Iowait)
+	return math.Min(100, math.Max(0, busy/total*100))
+}
+
+func modePercent(t1, t2 cpu.TimesStat) define.CPUStat {
+	total := cpuTotal(t2) - cpuTotal(t1)
+	if total <= 0 {
+		return define.CPUStat{}
+	}
+	percent := func(v1, v2 float64) float64 {
+		return math.Min(100, math.Max(0, (v2-v1)/total*100))
+	}
+	return define.CPUStat{
+		User:    percent(t1.User, t2.User),
+		System:  percent(t1.System, t2.System),
+		Nice:    percent(t1.Nice, t2.Nice),
+		Iowait:  percent(t1.Iowait, t2.Iowait),
+		Irq:     percent(t1.Irq, t2.Irq),
+		Softirq: percent(t1.Softirq, t2.Softirq),
+		Steal:   percent(t1.Steal, t2.Steal),
+		Idle:    percent(t1.Idle, t2.Idle),
+	}
+}
@@ -0,0 +1,119 @@
+package measure
+
+import (
+	"log/slog"
+	"sort"
+
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+func init() {
+	Register("disk", true, func(opts Options) Collector {
+		if opts.FstypeExclude == nil {
+			opts.FstypeExclude = DefaultFstypeExclude
+		}
+		return &diskCollector{
+			opts:      opts,
+			lastRead:  make(map[string]uint64),
+			lastWrite: make(map[string]uint64),
+		}
+	})
+}
+
+// diskCollector reports disk IO speed and the usage of every mounted
+// filesystem passing the mount and fstype filters.
+type diskCollector struct {
+	opts      Options
+	window    rateWindow
+	lastRead  map[string]uint64
+	lastWrite map[string]uint64
+}
+
+func (c *diskCollector) Name() string { return "disk" }
+
+func (c *diskCollector) Collect(s *Sample) error {
+	// Disk speed
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return err
+	}
+	var readBytes, writeBytes uint64
+	for name, counter := range counters {
+		readBytes += counterDelta(c.lastRead, name, counter.ReadBytes)
+		writeBytes += counterDelta(c.lastWrite, name, counter.WriteBytes)
+	}
+	// the first sample has no baseline, it only records the counters
+	if duration, first := c.window.next(s.Time); !first {
+		s.Result.Disk.Rx = readBytes / duration
+		s.Result.Disk.Wx = writeBytes / duration
+	}
+
+	// Usages
+	mounts, err := c.mounts()
+	if err != nil {
+		return err
+	}
+	var totalSize, usedSize, freeSize uint64
+	for _, m := range mounts {
+		totalSize += m.Total
+		usedSize += m.Used
+		freeSize += m.Free
+	}
+	s.Result.Disk.Mounts = mounts
+	s.Result.Disk.UsageStat = define.UsageStat{
+		Total: totalSize,
+		Used:  usedSize,
+		Free:  freeSize,
+	}
+	if totalSize > 0 {
+		s.Result.Percent.Disk = float64(usedSize) / float64(totalSize) * 100
+	}
+	return nil
+}
+
+// mounts returns one usage per device, a device mounted several times
+// (bind mounts) is reported once under its shortest mount path.
+func (c *diskCollector) mounts() ([]define.MountStat, error) {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return len(partitions[i].Mountpoint) < len(partitions[j].Mountpoint)
+	})
+
+	seen := make(map[string]struct{}, len(partitions))
+	result := make([]define.MountStat, 0, len(partitions))
+	for _, partition := range partitions {
+		if !matchFilter(partition.Fstype, c.opts.FstypeInclude, c.opts.FstypeExclude) ||
+			!matchFilter(partition.Mountpoint, c.opts.MountInclude, c.opts.MountExclude) {
+			continue
+		}
+		if _, ok := seen[partition.Device]; ok {
+			continue
+		}
+		usage, err := disk.Usage(partition.Mountpoint)
+		if err != nil {
+			slog.Debug("Disk usage", slog.String("mount", partition.Mountpoint), slog.String("err", err.Error()))
+			continue
+		}
+		seen[partition.Device] = struct{}{}
+		result = append(result, define.MountStat{
+			Path:          partition.Mountpoint,
+			Device:        partition.Device,
+			Fstype:        partition.Fstype,
+			Total:         usage.Total,
+			Used:          usage.Used,
+			Free:          usage.Free,
+			Percent:       usage.UsedPercent,
+			InodesTotal:   usage.InodesTotal,
+			InodesUsed:    usage.InodesUsed,
+			InodesPercent: usage.InodesUsedPercent,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
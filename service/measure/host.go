@@ -0,0 +1,78 @@
+package measure
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/host"
+)
+
+// bootDrift is the tolerance between two boot times of the same boot, the
+// boot time is derived from the clock and moves when it is adjusted.
+const bootDrift = 120
+
+func init() {
+	Register("host", true, func(opts Options) Collector {
+		return &hostCollector{bootFile: opts.BootFile}
+	})
+}
+
+// hostCollector reports host information and flags the first sample after
+// a reboot. The agent restarts with its host, so the last boot time is kept
+// in bootFile.
+type hostCollector struct {
+	bootFile string
+	lastBoot uint64
+	loaded   bool
+}
+
+func (c *hostCollector) Name() string { return "host" }
+
+func (c *hostCollector) Collect(s *Sample) error {
+	hostinfo, err := host.Info()
+	if err != nil {
+		return err
+	}
+	s.Result.Host.Hostname = hostinfo.Hostname
+	s.Result.Host.Uptime = hostinfo.Uptime
+	s.Result.Host.Platform = hostinfo.Platform
+	s.Result.Host.Version = hostinfo.PlatformVersion
+	s.Result.Host.Arch = hostinfo.KernelArch
+	s.Result.Host.Rebooted = c.rebooted(hostinfo.BootTime)
+	return nil
+}
+
+// rebooted reports whether boot differs from the last boot time seen, and
+// stores boot as the last one.
+func (c *hostCollector) rebooted(boot uint64) bool {
+	if !c.loaded {
+		c.loaded = true
+		c.lastBoot = readBootFile(c.bootFile)
+	}
+	drift := int64(boot) - int64(c.lastBoot)
+	if c.lastBoot != 0 && drift >= -bootDrift && drift <= bootDrift {
+		return false
+	}
+	rebooted := c.lastBoot != 0
+	c.lastBoot = boot
+	if c.bootFile != "" {
+		if err := os.WriteFile(c.bootFile, []byte(strconv.FormatUint(boot, 10)), 0o600); err != nil {
+			slog.Warn("Save boot time", slog.String("err", err.Error()))
+		}
+	}
+	return rebooted
+}
+
+func readBootFile(name string) uint64 {
+	if name == "" {
+		return 0
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	boot, _ := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	return boot
+}
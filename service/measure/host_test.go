@@ -0,0 +1,32 @@
+package measure
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHostRebooted(t *testing.T) {
+	bootFile := filepath.Join(t.TempDir(), "boot")
+	c := &hostCollector{bootFile: bootFile}
+	if c.rebooted(1000) {
+		t.Error("first boot time seen flagged as a reboot")
+	}
+	if c.rebooted(1030) {
+		t.Error("clock adjustment flagged as a reboot")
+	}
+
+	// the agent restarts with the host
+	c = &hostCollector{bootFile: bootFile}
+	if !c.rebooted(5000) {
+		t.Error("reboot not flagged")
+	}
+	if c.rebooted(5000) {
+		t.Error("reboot flagged twice")
+	}
+
+	// the agent restarts alone
+	c = &hostCollector{bootFile: bootFile}
+	if c.rebooted(5000) {
+		t.Error("agent restart flagged as a reboot")
+	}
+}
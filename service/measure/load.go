@@ -0,0 +1,23 @@
+package measure
+
+import "github.com/shirou/gopsutil/v4/load"
+
+func init() {
+	Register("load", true, func(Options) Collector { return new(loadCollector) })
+}
+
+// loadCollector reports the system load average.
+type loadCollector struct{}
+
+func (c *loadCollector) Name() string { return "load" }
+
+func (c *loadCollector) Collect(s *Sample) error {
+	loadAvg, err := load.Avg()
+	if err != nil {
+		return err
+	}
+	s.Result.Load.Load1 = loadAvg.Load1
+	s.Result.Load.Load5 = loadAvg.Load5
+	s.Result.Load.Load15 = loadAvg.Load15
+	return nil
+}
@@ -1,168 +1,196 @@
 package measure
 
 import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/shirou/gopsutil/v4/cpu"
-	"github.com/shirou/gopsutil/v4/disk"
-	"github.com/shirou/gopsutil/v4/host"
-	"github.com/shirou/gopsutil/v4/load"
-	"github.com/shirou/gopsutil/v4/mem"
-	"github.com/shirou/gopsutil/v4/net"
 	"github.com/zjyl1994/cloudstatus/infra/define"
-	"github.com/zjyl1994/cloudstatus/service/sensors"
 )
 
-var (
-	excludeInterfaceNamePrefix = []string{"lo", "tun", "docker", "veth", "br-", "vmbr", "vnet", "kube"}
-	measureTimestamp           int64
-	lastDiskRead               uint64
-	lastDiskWrite              uint64
-	lastNetworkSend            uint64
-	lastNetworkRecv            uint64
-)
+// Collector fills one group of metrics into a sample. Collectors keep their
+// own state between samples, such as the last value of counters.
+type Collector interface {
+	Name() string
+	Collect(s *Sample) error
+}
 
-func Measure(interval time.Duration, useSensors bool) (*define.StatExchangeFormat, error) {
-	var result define.StatExchangeFormat
-	// CPU Percent
-	if percent, err := cpu.Percent(interval, false); err == nil {
-		result.Percent.CPU = percent[0]
-	}
+// Preparer is implemented by collectors that need a baseline taken at the
+// start of the measure interval, such as CPU times.
+type Preparer interface {
+	Prepare() error
+}
 
-	now := time.Now().Unix()
-	duration := uint64(now - measureTimestamp)
+// Sample is the measurement in progress handed to every collector.
+type Sample struct {
+	Interval time.Duration
+	Time     time.Time
+	Result   *define.StatExchangeFormat
+}
 
-	// Disk speed
-	counters, err := disk.IOCounters()
-	if err != nil {
-		return nil, err
-	}
-	var readBytes, writeBytes uint64
-	for _, c := range counters {
-		readBytes += c.ReadBytes
-		writeBytes += c.WriteBytes
-	}
+// Options configures the collectors.
+type Options struct {
+	// glob patterns of mount paths and filesystem types to report,
+	// include lists are ignored when empty
+	MountInclude  []string
+	MountExclude  []string
+	FstypeInclude []string
+	FstypeExclude []string
+	// glob patterns of network interfaces to report
+	InterfaceInclude []string
+	InterfaceExclude []string
+	// root of the sysfs tree read by the sensors collector
+	SysfsRoot string
+	// file keeping the last boot time, the host collector flags the first
+	// sample after a reboot through it
+	BootFile string
+}
 
-	result.Disk.Rx = (readBytes - lastDiskRead) / duration
-	result.Disk.Wx = (writeBytes - lastDiskWrite) / duration
+// DefaultFstypeExclude lists the virtual filesystems skipped by default.
+var DefaultFstypeExclude = []string{"tmpfs", "devtmpfs", "squashfs", "overlay"}
 
-	lastDiskRead = readBytes
-	lastDiskWrite = writeBytes
+// DefaultInterfaceExclude lists the loopback and virtual interfaces skipped
+// by default.
+var DefaultInterfaceExclude = []string{"lo", "tun*", "docker*", "veth*", "br-*", "vmbr*", "vnet*", "kube*"}
 
-	// Network speed
-	in, out, err := getNetInOut()
-	if err != nil {
-		return nil, err
-	}
+type registration struct {
+	factory func(opts Options) Collector
+	enabled bool
+}
 
-	result.Network.Recv = in - lastNetworkRecv
-	result.Network.Send = out - lastNetworkSend
-	result.Network.Rx = result.Network.Recv / duration
-	result.Network.Tx = result.Network.Send / duration
-	lastNetworkRecv = in
-	lastNetworkSend = out
+var registry = make(map[string]registration)
 
-	measureTimestamp = now
+// Register adds a collector factory, enabled marks it as a default one.
+func Register(name string, enabled bool, factory func(opts Options) Collector) {
+	registry[name] = registration{factory: factory, enabled: enabled}
+}
 
-	// Load
-	loadAvg, err := load.Avg()
-	if err != nil {
-		return nil, err
-	}
-	result.Load.Load1 = loadAvg.Load1
-	result.Load.Load5 = loadAvg.Load5
-	result.Load.Load15 = loadAvg.Load15
-	// Usages
-	vm, err := mem.VirtualMemory()
-	if err != nil {
-		return nil, err
+// Names returns every registered collector name.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
 	}
-	result.Memory = define.UsageStat{
-		Total: vm.Total,
-		Used:  vm.Used,
-		Free:  vm.Free,
-	}
-	result.Percent.Mem = vm.UsedPercent
+	sort.Strings(names)
+	return names
+}
 
-	sm, err := mem.SwapMemory()
-	if err != nil {
-		return nil, err
-	}
-	result.Swap = define.UsageStat{
-		Total: sm.Total,
-		Used:  sm.Used,
-		Free:  sm.Free,
+// DefaultNames returns the collectors enabled by default.
+func DefaultNames() []string {
+	var names []string
+	for _, name := range Names() {
+		if registry[name].enabled {
+			names = append(names, name)
+		}
 	}
-	result.Percent.Swap = sm.UsedPercent
+	return names
+}
 
-	partitions, err := disk.Partitions(false)
-	if err != nil {
-		return nil, err
-	}
+// Measurer runs a set of collectors every interval.
+type Measurer struct {
+	collectors []Collector
+}
 
-	var totalSize, usedSize, freeSize uint64
-	for _, partition := range partitions {
-		diskUsage, diskErr := disk.Usage(partition.Mountpoint)
-		if diskErr != nil {
-			return nil, diskErr
+// New creates a measurer from registered collector names.
+func New(names []string, opts Options) (*Measurer, error) {
+	collectors := make([]Collector, 0, len(names))
+	for _, name := range names {
+		reg, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q, available: %s", name, strings.Join(Names(), ","))
 		}
-		totalSize += diskUsage.Total
-		usedSize += diskUsage.Used
-		freeSize += diskUsage.Free
+		collectors = append(collectors, reg.factory(opts))
 	}
+	return NewWithCollectors(collectors...), nil
+}
+
+// NewWithCollectors creates a measurer from collector instances, it lets
+// tests plug in fake collectors.
+func NewWithCollectors(collectors ...Collector) *Measurer {
+	return &Measurer{collectors: collectors}
+}
 
-	result.Disk.UsageStat = define.UsageStat{
-		Total: totalSize,
-		Used:  usedSize,
-		Free:  freeSize,
+// SelectNames applies enable and disable lists to the default collectors.
+func SelectNames(enable, disable []string) []string {
+	names := DefaultNames()
+	for _, name := range enable {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
 	}
-	if totalSize > 0 {
-		result.Percent.Disk = float64(usedSize) / float64(totalSize) * 100
+	return slices.DeleteFunc(names, func(name string) bool {
+		return slices.Contains(disable, name)
+	})
+}
+
+// Measure waits for interval and returns the sample of every collector.
+func (m *Measurer) Measure(interval time.Duration) (*define.StatExchangeFormat, error) {
+	for _, c := range m.collectors {
+		if p, ok := c.(Preparer); ok {
+			if err := p.Prepare(); err != nil {
+				return nil, fmt.Errorf("%s: %w", c.Name(), err)
+			}
+		}
 	}
-	// host info
-	hostinfo, err := host.Info()
-	if err != nil {
-		return nil, err
+	time.Sleep(interval)
+
+	var result define.StatExchangeFormat
+	s := &Sample{Interval: interval, Time: time.Now(), Result: &result}
+	for _, c := range m.collectors {
+		if err := c.Collect(s); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Name(), err)
+		}
 	}
-	result.Host.Hostname = hostinfo.Hostname
-	result.Host.Uptime = hostinfo.Uptime
-	result.Host.Platform = hostinfo.Platform
-	result.Host.Version = hostinfo.PlatformVersion
-	result.Host.Arch = hostinfo.KernelArch
 
 	// info
 	result.Interval = uint64(interval.Seconds())
-	result.ReportTime = now
+	result.ReportTime = s.Time.Unix()
+	return &result, nil
+}
 
-	// temperature
-	if useSensors {
-		if temperatures, err := sensors.Get(); err == nil {
-			result.Temperature = temperatures
-		}
+// counterDelta returns the growth of a monotonic counter since the last
+// sample and stores the new value. A counter lower than before was reset
+// (reboot, driver reload, interface recreated), so it grew from zero.
+func counterDelta(last map[string]uint64, name string, current uint64) uint64 {
+	prev, ok := last[name]
+	last[name] = current
+	if !ok {
+		return 0
 	}
+	if current < prev {
+		return current
+	}
+	return current - prev
+}
 
-	return &result, nil
+// rateWindow tracks the time between samples of a counter collector.
+type rateWindow struct {
+	last time.Time
 }
 
-func getNetInOut() (netIn uint64, netOut uint64, err error) {
-	nv, err := net.IOCounters(true)
-	if err != nil {
-		return 0, 0, err
-	}
-	for _, v := range nv {
-		if matchPrefix(v.Name, excludeInterfaceNamePrefix) {
-			continue
-		}
-		netIn += v.BytesRecv
-		netOut += v.BytesSent
+// next returns the seconds since the previous sample, first is true when
+// there is no previous sample to compute rates from.
+func (w *rateWindow) next(now time.Time) (seconds uint64, first bool) {
+	first = w.last.IsZero()
+	seconds = uint64(max(now.Unix()-w.last.Unix(), 1))
+	w.last = now
+	return seconds, first
+}
+
+// matchFilter reports whether s passes the include and exclude glob lists.
+func matchFilter(s string, include, exclude []string) bool {
+	if len(include) > 0 && !matchGlob(s, include) {
+		return false
 	}
-	return netIn, netOut, nil
+	return !matchGlob(s, exclude)
 }
 
-func matchPrefix(s string, prefixs []string) bool {
-	for _, prefix := range prefixs {
-		if strings.HasPrefix(s, prefix) {
+func matchGlob(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
 			return true
 		}
 	}
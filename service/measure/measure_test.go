@@ -0,0 +1,117 @@
+package measure
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeCollector reports a scripted counter as the network send total, one
+// value per sample.
+type fakeCollector struct {
+	name     string
+	counters []uint64
+	last     map[string]uint64
+	prepared int
+	err      error
+}
+
+func newFake(name string, counters ...uint64) *fakeCollector {
+	return &fakeCollector{name: name, counters: counters, last: make(map[string]uint64)}
+}
+
+func (c *fakeCollector) Name() string { return c.name }
+
+func (c *fakeCollector) Prepare() error {
+	c.prepared++
+	return nil
+}
+
+func (c *fakeCollector) Collect(s *Sample) error {
+	if c.err != nil {
+		return c.err
+	}
+	s.Result.Network.Send += counterDelta(c.last, "eth0", c.counters[0])
+	c.counters = c.counters[1:]
+	return nil
+}
+
+func TestMeasureFakeCollectors(t *testing.T) {
+	a := newFake("a", 100, 150, 40)
+	b := newFake("b", 1000, 1010, 1020)
+	m := NewWithCollectors(a, b)
+	var sends []uint64
+	for range 3 {
+		result, err := m.Measure(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sends = append(sends, result.Network.Send)
+	}
+	// nothing on the first sample, then each collector grows from its own
+	// last counter, a reset one from zero
+	if want := []uint64{0, 60, 50}; !slices.Equal(sends, want) {
+		t.Errorf("got sends %v, want %v", sends, want)
+	}
+	if a.prepared != 3 || b.prepared != 3 {
+		t.Errorf("prepared %d and %d times, want 3", a.prepared, b.prepared)
+	}
+}
+
+func TestMeasureError(t *testing.T) {
+	failing := newFake("failing", 1)
+	failing.err = errors.New("broken")
+	_, err := NewWithCollectors(newFake("ok", 1), failing).Measure(0)
+	if !errors.Is(err, failing.err) || !strings.HasPrefix(err.Error(), "failing:") {
+		t.Errorf("got %v, want the error of the failing collector", err)
+	}
+}
+
+func TestSelectNames(t *testing.T) {
+	Register("fake", false, func(Options) Collector { return newFake("fake", 0) })
+	t.Cleanup(func() { delete(registry, "fake") })
+
+	names := SelectNames([]string{"fake", "cpu"}, []string{"disk"})
+	if !slices.Contains(names, "fake") || slices.Contains(names, "disk") {
+		t.Errorf("got %v, want fake enabled and disk disabled", names)
+	}
+	if n := len(names); n != len(DefaultNames()) {
+		t.Errorf("got %d collectors, want the %d defaults with one swapped", n, len(DefaultNames()))
+	}
+	if slices.Contains(SelectNames(nil, nil), "fake") {
+		t.Error("collector off by default was enabled")
+	}
+
+	m, err := New([]string{"fake"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.collectors) != 1 || m.collectors[0].Name() != "fake" {
+		t.Errorf("got collectors %v", m.collectors)
+	}
+	if _, err = New([]string{"missing"}, Options{}); err == nil {
+		t.Error("unknown collector accepted")
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	last := make(map[string]uint64)
+	for i, tt := range []struct {
+		current, want uint64
+	}{
+		{100, 0},
+		{150, 50},
+		{150, 0},
+		// reset by a reboot or a recreated interface, it grew from zero
+		{30, 30},
+		{45, 15},
+	} {
+		if got := counterDelta(last, "eth0", tt.current); got != tt.want {
+			t.Errorf("%d: counterDelta(%d) = %d, want %d", i, tt.current, got, tt.want)
+		}
+	}
+	if got := counterDelta(last, "eth1", 500); got != 0 {
+		t.Errorf("new counter grew by %d, want 0", got)
+	}
+}
@@ -0,0 +1,40 @@
+package measure
+
+import (
+	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+func init() {
+	Register("memory", true, func(Options) Collector { return new(memoryCollector) })
+}
+
+// memoryCollector reports memory and swap usage.
+type memoryCollector struct{}
+
+func (c *memoryCollector) Name() string { return "memory" }
+
+func (c *memoryCollector) Collect(s *Sample) error {
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return err
+	}
+	s.Result.Memory = define.UsageStat{
+		Total: vm.Total,
+		Used:  vm.Used,
+		Free:  vm.Free,
+	}
+	s.Result.Percent.Mem = vm.UsedPercent
+
+	sm, err := mem.SwapMemory()
+	if err != nil {
+		return err
+	}
+	s.Result.Swap = define.UsageStat{
+		Total: sm.Total,
+		Used:  sm.Used,
+		Free:  sm.Free,
+	}
+	s.Result.Percent.Swap = sm.UsedPercent
+	return nil
+}
@@ -0,0 +1,86 @@
+package measure
+
+import (
+	"sort"
+
+	"github.com/shirou/gopsutil/v4/net"
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+func init() {
+	Register("network", true, func(opts Options) Collector {
+		if opts.InterfaceExclude == nil {
+			opts.InterfaceExclude = DefaultInterfaceExclude
+		}
+		return &networkCollector{
+			opts: opts,
+			last: make(map[string]map[string]uint64),
+		}
+	})
+}
+
+// networkCollector reports network speed and traffic of the interval, in
+// total and for every interface passing the interface filters.
+type networkCollector struct {
+	opts   Options
+	window rateWindow
+	// last counter values by counter name then interface name
+	last map[string]map[string]uint64
+}
+
+func (c *networkCollector) Name() string { return "network" }
+
+func (c *networkCollector) delta(counter, name string, current uint64) uint64 {
+	last, ok := c.last[counter]
+	if !ok {
+		last = make(map[string]uint64)
+		c.last[counter] = last
+	}
+	return counterDelta(last, name, current)
+}
+
+func (c *networkCollector) Collect(s *Sample) error {
+	nv, err := net.IOCounters(true)
+	if err != nil {
+		return err
+	}
+	var in, out uint64
+	interfaces := make([]define.InterfaceStat, 0, len(nv))
+	for _, v := range nv {
+		if !matchFilter(v.Name, c.opts.InterfaceInclude, c.opts.InterfaceExclude) {
+			continue
+		}
+		stat := define.InterfaceStat{
+			Name:        v.Name,
+			Recv:        c.delta("bytes_recv", v.Name, v.BytesRecv),
+			Send:        c.delta("bytes_sent", v.Name, v.BytesSent),
+			PacketsRecv: c.delta("packets_recv", v.Name, v.PacketsRecv),
+			PacketsSent: c.delta("packets_sent", v.Name, v.PacketsSent),
+			ErrIn:       c.delta("err_in", v.Name, v.Errin),
+			ErrOut:      c.delta("err_out", v.Name, v.Errout),
+			DropIn:      c.delta("drop_in", v.Name, v.Dropin),
+			DropOut:     c.delta("drop_out", v.Name, v.Dropout),
+		}
+		in += stat.Recv
+		out += stat.Send
+		interfaces = append(interfaces, stat)
+	}
+	// the first sample has no baseline, it only records the counters
+	duration, first := c.window.next(s.Time)
+	if first {
+		return nil
+	}
+	for i := range interfaces {
+		interfaces[i].Rx = interfaces[i].Recv / duration
+		interfaces[i].Tx = interfaces[i].Send / duration
+	}
+	sort.Slice(interfaces, func(i, j int) bool {
+		return interfaces[i].Name < interfaces[j].Name
+	})
+	s.Result.Network.Recv = in
+	s.Result.Network.Send = out
+	s.Result.Network.Rx = in / duration
+	s.Result.Network.Tx = out / duration
+	s.Result.Network.Interfaces = interfaces
+	return nil
+}
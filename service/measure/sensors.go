@@ -0,0 +1,28 @@
+package measure
+
+import "github.com/zjyl1994/cloudstatus/service/sensors"
+
+func init() {
+	Register("sensors", false, func(opts Options) Collector {
+		if opts.SysfsRoot == "" {
+			opts.SysfsRoot = sensors.DefaultSysfsRoot
+		}
+		return &sensorsCollector{sysfsRoot: opts.SysfsRoot}
+	})
+}
+
+// sensorsCollector reports temperatures, it is best effort since many
+// hosts have no sensors at all.
+type sensorsCollector struct {
+	sysfsRoot string
+}
+
+func (c *sensorsCollector) Name() string { return "sensors" }
+
+func (c *sensorsCollector) Collect(s *Sample) error {
+	if readings, err := sensors.Get(c.sysfsRoot); err == nil {
+		s.Result.Sensors = readings
+		s.Result.Temperature = sensors.Temperatures(readings)
+	}
+	return nil
+}
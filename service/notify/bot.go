@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+const defaultBotEndpoint = "https://api.telegram.org"
+
+// bot sends messages through a Telegram style bot API:
+// POST {endpoint}/bot{token}/sendMessage.
+type bot struct {
+	endpoint string
+	token    string
+	chatID   string
+}
+
+func newBot(cfg define.NotifierConfig) *bot {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultBotEndpoint
+	}
+	return &bot{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    cfg.Token,
+		chatID:   cfg.ChatID,
+	}
+}
+
+func (b *bot) Send(ctx context.Context, event define.AlertEvent, message string) error {
+	body, err := json.Marshal(map[string]string{
+		"chat_id": b.chatID,
+		"text":    message,
+	})
+	if err != nil {
+		return err
+	}
+	url := b.endpoint + "/bot" + b.token + "/sendMessage"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(req)
+}
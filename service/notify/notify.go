@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+const defaultTemplate = `[{{.State}}] {{.Rule.Name}} on {{if .Node.Label}}{{.Node.Label}}{{else}}{{.Node.ID}}{{end}}: {{.Rule.Metric}}{{with .Rule.Sensor}}({{.}}){{end}} = {{printf "%.2f" .Value}}`
+
+const sendTimeout = 30 * time.Second
+
+// Notifier delivers a rendered alert message to one channel.
+type Notifier interface {
+	Send(ctx context.Context, event define.AlertEvent, message string) error
+}
+
+// Channel is a configured notifier with its template and retry policy.
+type Channel struct {
+	Name     string
+	notifier Notifier
+	tmpl     *template.Template
+	retries  int
+}
+
+var (
+	channels = make(map[string]*Channel)
+	lock     sync.RWMutex
+)
+
+// New builds a channel from config.
+func New(cfg define.NotifierConfig) (*Channel, error) {
+	var notifier Notifier
+	switch cfg.Type {
+	case "webhook":
+		notifier = newWebhook(cfg)
+	case "smtp":
+		notifier = newSMTP(cfg)
+	case "telegram", "bot":
+		notifier = newBot(cfg)
+	default:
+		return nil, fmt.Errorf("notifier %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+
+	text := cfg.Template
+	if text == "" {
+		text = defaultTemplate
+	}
+	tmpl, err := template.New(cfg.Name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("notifier %q: %w", cfg.Name, err)
+	}
+	return &Channel{
+		Name:     cfg.Name,
+		notifier: notifier,
+		tmpl:     tmpl,
+		retries:  max(cfg.Retries, 0),
+	}, nil
+}
+
+// Load replaces the configured channels.
+func Load(cfgs []define.NotifierConfig) error {
+	result := make(map[string]*Channel, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return errors.New("notifier name is empty")
+		}
+		if _, ok := result[cfg.Name]; ok {
+			return fmt.Errorf("duplicate notifier %q", cfg.Name)
+		}
+		ch, err := New(cfg)
+		if err != nil {
+			return err
+		}
+		result[cfg.Name] = ch
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	channels = result
+	return nil
+}
+
+// Dispatch sends an alert event to the channels selected by its rule,
+// or to every channel when the rule does not name any.
+func Dispatch(event define.AlertEvent) {
+	lock.RLock()
+	targets := make([]*Channel, 0, len(channels))
+	for name, ch := range channels {
+		if len(event.Rule.Channels) == 0 || slices.Contains(event.Rule.Channels, name) {
+			targets = append(targets, ch)
+		}
+	}
+	lock.RUnlock()
+
+	for _, ch := range targets {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout*time.Duration(ch.retries+1))
+		if err := ch.Send(ctx, event); err != nil {
+			slog.Error("Notify failed", slog.String("channel", ch.Name), slog.String("err", err.Error()))
+		}
+		cancel()
+	}
+}
+
+// Send renders the event and delivers it, retrying with exponential backoff.
+func (ch *Channel) Send(ctx context.Context, event define.AlertEvent) error {
+	var buf bytes.Buffer
+	if err := ch.tmpl.Execute(&buf, event); err != nil {
+		return err
+	}
+	message := buf.String()
+
+	backoff := time.Second
+	var err error
+	for attempt := 0; attempt <= ch.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err = ch.notifier.Send(sendCtx, event, message)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		slog.Debug("Notify attempt failed", slog.String("channel", ch.Name), slog.Int("attempt", attempt+1), slog.String("err", err.Error()))
+	}
+	return err
+}
+
+// SampleEvent returns a fake firing event used to test channels.
+func SampleEvent(node define.ServerNode) define.AlertEvent {
+	now := time.Now()
+	return define.AlertEvent{
+		Rule: define.AlertRule{
+			Name:      "test",
+			Metric:    "cpu",
+			Operator:  ">",
+			Threshold: 90,
+			For:       "5m",
+		},
+		Node:  node.Public(),
+		State: define.AlertFiring,
+		Value: 95.5,
+		Since: now.Add(-5 * time.Minute),
+		Time:  now,
+	}
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+// webhook posts a JSON document with the message and the raw event.
+type webhook struct {
+	url     string
+	headers map[string]string
+}
+
+type webhookBody struct {
+	Text  string            `json:"text"`
+	Event define.AlertEvent `json:"event"`
+}
+
+func newWebhook(cfg define.NotifierConfig) *webhook {
+	return &webhook{url: cfg.Endpoint, headers: cfg.Headers}
+}
+
+func (w *webhook) Send(ctx context.Context, event define.AlertEvent, message string) error {
+	body, err := json.Marshal(webhookBody{Text: message, Event: event})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	return doRequest(req)
+}
+
+func doRequest(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("bad response code %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package record
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+const (
+	AggP95  = "p95"
+	AggLast = "last"
+)
+
+// SeriesAggs lists the aggregates accepted by LoadSeries.
+var SeriesAggs = []string{AggAvg, AggMin, AggMax, AggP95, AggLast}
+
+// LoadSeries loads the history of a node between startTime and endTime in
+// ascending time order. With a step the points are bucketed into step
+// seconds with agg, over every point of the window. Avg, min and max read
+// the coarsest rollup level not above step, as rollups keep them exactly,
+// the avg weighs each rollup by its samples. P95 and last need the
+// distribution and read the finest level that fits, once raw records are
+// past retention that is a rollup level: p95 is then taken over its bucket
+// averages and last is the average of the last bucket, both smoother than
+// over raw records.
+func LoadSeries(nodeId string, startTime, endTime, step int64, agg string) (resolution int64, records []define.MeasureRecord, err error) {
+	if agg == "" {
+		agg = AggAvg
+	}
+	if !slices.Contains(SeriesAggs, agg) {
+		return 0, nil, fmt.Errorf("unknown agg %q, available: %s", agg, strings.Join(SeriesAggs, ","))
+	}
+	sourceAgg := AggAvg
+	switch agg {
+	case AggMin, AggMax:
+		sourceAgg = agg
+		resolution = PickResolution(startTime, endTime, step)
+	case AggAvg:
+		resolution = PickResolution(startTime, endTime, step)
+	default:
+		resolution = PickResolution(startTime, endTime, 0)
+	}
+	var samples []int64
+	if resolution == 0 {
+		records, err = LoadRecord(nodeId, startTime, endTime)
+		// raw records are picked for the report interval of most agents, the
+		// window of a faster one does not fit and bucketed points need all
+		// of it, they are served from the first rollup level then
+		if err == nil && step > 0 && len(records) == maxLoadRows {
+			resolution = Resolutions[0]
+		}
+	}
+	if resolution != 0 {
+		var rollups []define.MeasureRollup
+		rollups, err = LoadRollup(nodeId, resolution, sourceAgg, startTime, endTime)
+		records = make([]define.MeasureRecord, 0, len(rollups))
+		samples = make([]int64, 0, len(rollups))
+		for _, r := range rollups {
+			records = append(records, define.MeasureRecord{
+				NodeID:        r.NodeID,
+				Timestamp:     r.Timestamp,
+				MeasureValues: r.MeasureValues,
+			})
+			samples = append(samples, r.Samples)
+		}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	// loaded newest first
+	slices.Reverse(records)
+	slices.Reverse(samples)
+	if step > max(resolution, 1) {
+		records = bucketWeighted(records, samples, step, agg)
+	}
+	return resolution, records, nil
+}
+
+// Bucket aggregates records sorted by time into buckets of step seconds
+// aligned to the epoch, each field and each key of the JSON map fields
+// on its own. The result is stamped with the bucket start.
+func Bucket(records []define.MeasureRecord, step int64, agg string) []define.MeasureRecord {
+	return bucketWeighted(records, nil, step, agg)
+}
+
+// bucketWeighted is Bucket with the avg weighing record i by weights[i],
+// records weigh the same without weights.
+func bucketWeighted(records []define.MeasureRecord, weights []int64, step int64, agg string) []define.MeasureRecord {
+	var result []define.MeasureRecord
+	var bucket seriesAgg
+	start := int64(-1)
+	for i := range records {
+		ts := records[i].Timestamp / step * step
+		if ts != start && start >= 0 {
+			result = append(result, bucket.result(records[i-1].NodeID, start, agg))
+			bucket = seriesAgg{}
+		}
+		start = ts
+		weight := 1.0
+		if weights != nil {
+			weight = float64(max(weights[i], 1))
+		}
+		bucket.add(&records[i].MeasureValues, weight)
+	}
+	if start >= 0 {
+		result = append(result, bucket.result(records[len(records)-1].NodeID, start, agg))
+	}
+	return result
+}
+
+// seriesAgg keeps every value of a bucket with its weight, field by field
+// like valuesAgg.
+type seriesAgg struct {
+	nums [][]weighted
+	maps []map[string][]weighted
+}
+
+type weighted struct {
+	value, weight float64
+}
+
+func (a *seriesAgg) add(values *define.MeasureValues, weight float64) {
+	if a.nums == nil {
+		a.nums = make([][]weighted, valuesType.NumField())
+		a.maps = make([]map[string][]weighted, valuesType.NumField())
+	}
+	v := reflect.ValueOf(values).Elem()
+	for i := 0; i < valuesType.NumField(); i++ {
+		if valuesType.Field(i).Type.Kind() == reflect.String {
+			m := decodeMap(v.Field(i))
+			if len(m) == 0 {
+				continue
+			}
+			if a.maps[i] == nil {
+				a.maps[i] = make(map[string][]weighted)
+			}
+			for k, f := range m {
+				a.maps[i][k] = append(a.maps[i][k], weighted{f, weight})
+			}
+			continue
+		}
+		a.nums[i] = append(a.nums[i], weighted{toFloat(v.Field(i)), weight})
+	}
+}
+
+func (a *seriesAgg) result(nodeId string, timestamp int64, agg string) define.MeasureRecord {
+	r := define.MeasureRecord{NodeID: nodeId, Timestamp: timestamp}
+	v := reflect.ValueOf(&r.MeasureValues).Elem()
+	for i := 0; i < valuesType.NumField(); i++ {
+		if valuesType.Field(i).Type.Kind() == reflect.String {
+			m := make(map[string]float64, len(a.maps[i]))
+			for k, values := range a.maps[i] {
+				m[k] = reduce(values, agg)
+			}
+			encodeMap(v.Field(i), m)
+			continue
+		}
+		if len(a.nums[i]) > 0 {
+			setFloat(v.Field(i), reduce(a.nums[i], agg))
+		}
+	}
+	return r
+}
+
+// reduce aggregates the values of a bucket in time order, p95 is the
+// nearest rank percentile and avg is weighted.
+func reduce(values []weighted, agg string) float64 {
+	switch agg {
+	case AggMin:
+		return slices.MinFunc(values, compareWeighted).value
+	case AggMax:
+		return slices.MaxFunc(values, compareWeighted).value
+	case AggLast:
+		return values[len(values)-1].value
+	case AggP95:
+		sorted := slices.Clone(values)
+		slices.SortFunc(sorted, compareWeighted)
+		rank := int(math.Ceil(0.95 * float64(len(sorted))))
+		return sorted[max(rank, 1)-1].value
+	}
+	var sum, weights float64
+	for _, w := range values {
+		sum += w.value * w.weight
+		weights += w.weight
+	}
+	return sum / weights
+}
+
+func compareWeighted(a, b weighted) int {
+	return cmp.Compare(a.value, b.value)
+}
@@ -0,0 +1,44 @@
+package record
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+// bootDrift is the tolerance of boot time computed from report time and
+// uptime, both are rounded to seconds and clocks may be adjusted.
+const bootDrift = 120
+
+// DetectReboot reports whether cur was measured after a reboot, either
+// flagged by the agent or seen as a boot time change against prev. The
+// agent flag survives server restarts and replayed backlogs, prev is the
+// fallback for agents without a boot file.
+func DetectReboot(prev *define.StatExchangeFormat, cur *define.StatExchangeFormat) bool {
+	if cur.Host.Rebooted {
+		return true
+	}
+	if prev == nil || prev.Host.Uptime == 0 || cur.Host.Uptime == 0 || cur.ReportTime <= prev.ReportTime {
+		return false
+	}
+	prevBoot := prev.ReportTime - int64(prev.Host.Uptime)
+	curBoot := cur.ReportTime - int64(cur.Host.Uptime)
+	return curBoot-prevBoot > bootDrift
+}
+
+// WriteRebootEvent records a reboot of the node of def, once when the
+// report is retried.
+func WriteRebootEvent(def *define.StatExchangeFormat) error {
+	bootTime := def.ReportTime - int64(def.Host.Uptime)
+	return store.InsertEvent(&define.NodeEvent{
+		NodeID:    def.NodeID,
+		Timestamp: bootTime,
+		Type:      define.NodeEventReboot,
+		Message:   fmt.Sprintf("Host %s booted at %s", def.Host.Hostname, time.Unix(bootTime, 0).Format(time.DateTime)),
+	})
+}
+
+func LoadEvent(nodeId string, startTime, endTime int64) ([]define.NodeEvent, error) {
+	return store.LoadEvents(nodeId, startTime, endTime, 1000)
+}
@@ -0,0 +1,200 @@
+package record
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// ExportFormats lists the accepted ExportQuery formats.
+var ExportFormats = []string{ExportCSV, ExportNDJSON}
+
+// ExportQuery selects the raw records to export.
+type ExportQuery struct {
+	Nodes      []string
+	Start, End int64
+	// Columns are measure_records column names, empty for all but the id.
+	Columns []string
+	Format  string
+}
+
+// Exporter streams the records of a query row by row.
+type Exporter struct {
+	query  ExportQuery
+	fields []*schema.Field
+}
+
+// ExportColumns returns the columns an export can select.
+func ExportColumns() ([]string, error) {
+	_, columns, err := exportSchema()
+	return columns, err
+}
+
+func exportSchema() (*schema.Schema, []string, error) {
+	s, err := schema.Parse(&define.MeasureRecord{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := slices.DeleteFunc(slices.Clone(s.DBNames), func(name string) bool {
+		return name == "id"
+	})
+	return s, columns, nil
+}
+
+// NewExporter checks a query before anything is written.
+func NewExporter(q ExportQuery) (*Exporter, error) {
+	if q.Format == "" {
+		q.Format = ExportCSV
+	}
+	i := slices.Index(ExportFormats, q.Format)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown format %q, available: %s", q.Format, strings.Join(ExportFormats, ","))
+	}
+	// the format may point into a request buffer, keep the constant
+	q.Format = ExportFormats[i]
+	if q.Start > q.End {
+		return nil, fmt.Errorf("start time must be less than end time")
+	}
+	s, available, err := exportSchema()
+	if err != nil {
+		return nil, err
+	}
+	if len(q.Columns) == 0 {
+		q.Columns = available
+	}
+	e := &Exporter{query: q}
+	for _, name := range q.Columns {
+		field := s.LookUpField(name)
+		if field == nil || !slices.Contains(available, field.DBName) {
+			return nil, fmt.Errorf("unknown column %q, available: %s", name, strings.Join(available, ","))
+		}
+		e.fields = append(e.fields, field)
+	}
+	// the columns may point into a request buffer, keep the schema names
+	e.query.Columns = make([]string, len(e.fields))
+	for i, field := range e.fields {
+		e.query.Columns[i] = field.DBName
+	}
+	return e, nil
+}
+
+// ContentType returns the MIME type of the export format.
+func (e *Exporter) ContentType() string {
+	if e.query.Format == ExportNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Write streams the records to w ordered by node and time, without
+// loading them all at once.
+func (e *Exporter) Write(w io.Writer) error {
+	rows, err := store.ExportRecords(e.query.Columns, e.query.Nodes, e.query.Start, e.query.End)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// scan through pointers, columns added by later versions are NULL in
+	// older rows
+	dest := make([]any, len(e.fields))
+	for i, field := range e.fields {
+		dest[i] = reflect.New(reflect.PointerTo(field.FieldType)).Interface()
+	}
+	bw := bufio.NewWriter(w)
+	var cw *csv.Writer
+	if e.query.Format == ExportCSV {
+		cw = csv.NewWriter(bw)
+		if err = cw.Write(e.query.Columns); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(e.fields))
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+		if cw != nil {
+			for i := range dest {
+				record[i] = exportText(reflect.ValueOf(dest[i]).Elem().Elem())
+			}
+			err = cw.Write(record)
+		} else {
+			err = e.writeJSON(bw, dest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if cw != nil {
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeJSON writes one row as a JSON object in column order. The JSON
+// encoded map columns are embedded as objects.
+func (e *Exporter) writeJSON(w *bufio.Writer, dest []any) error {
+	w.WriteByte('{')
+	for i, field := range e.fields {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.DBName)
+		w.Write(name)
+		w.WriteByte(':')
+		v := reflect.ValueOf(dest[i]).Elem()
+		if v.IsNil() {
+			w.WriteString("null")
+			continue
+		}
+		v = v.Elem()
+		if v.Kind() == reflect.String && field.DBName != "node_id" && json.Valid([]byte(v.String())) {
+			w.WriteString(v.String())
+			continue
+		}
+		b, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+	}
+	w.WriteByte('}')
+	_, err := w.WriteString("\n")
+	return err
+}
+
+func exportText(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return ""
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	}
+	return v.String()
+}
@@ -0,0 +1,184 @@
+package record
+
+import (
+	"database/sql"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormStore keeps the data through gorm, which covers every driver of
+// infra/storage. Queries stick to what sqlite, postgres and mysql share.
+type gormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore returns a store of db.
+func NewGormStore(db *gorm.DB) Store {
+	return gormStore{db: db}
+}
+
+// conn returns the database of the store, vars.DB for the default store.
+func (s gormStore) conn() *gorm.DB {
+	if s.db == nil {
+		return vars.DB
+	}
+	return s.db
+}
+
+func (s gormStore) Transaction(fn func(tx Store) error) error {
+	return s.conn().Transaction(func(tx *gorm.DB) error {
+		return fn(gormStore{db: tx})
+	})
+}
+
+func (s gormStore) InsertRecord(r *define.MeasureRecord) (bool, error) {
+	result := s.conn().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}, {Name: "timestamp"}},
+		DoNothing: true,
+	}).Create(r)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s gormStore) LoadRecords(nodeId string, start, end int64, limit int) ([]define.MeasureRecord, error) {
+	var records []define.MeasureRecord
+	err := limitRows(s.conn().Where("node_id = ? AND timestamp >= ? AND timestamp <= ?", nodeId, start, end), limit).
+		Order("timestamp desc").Find(&records).Error
+	return records, err
+}
+
+func (s gormStore) ScanTraffic(fn func(r *define.MeasureRecord) error) error {
+	rows, err := s.conn().Model(&define.MeasureRecord{}).
+		Select("node_id, timestamp, net_send, net_recv").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r define.MeasureRecord
+		if err = rows.Scan(&r.NodeID, &r.Timestamp, &r.NetSend, &r.NetRecv); err != nil {
+			return err
+		}
+		if err = fn(&r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s gormStore) ExportRecords(columns, nodes []string, start, end int64) (*sql.Rows, error) {
+	return s.conn().Model(&define.MeasureRecord{}).
+		Select(columns).
+		Where("node_id IN ? AND timestamp >= ? AND timestamp <= ?", nodes, start, end).
+		Order("node_id, timestamp").Rows()
+}
+
+func (s gormStore) DeleteRecords(keep []string, before int64) error {
+	err := s.conn().Where("node_id NOT IN ?", keep).Delete(&define.MeasureRecord{}).Error
+	if err != nil || before <= 0 {
+		return err
+	}
+	return s.conn().Where("timestamp < ?", before).Delete(&define.MeasureRecord{}).Error
+}
+
+// level returns the query of a rollup level, resolution 0 is raw records.
+func (s gormStore) level(resolution int64) *gorm.DB {
+	if resolution == 0 {
+		return s.conn().Model(&define.MeasureRecord{})
+	}
+	return s.conn().Model(&define.MeasureRollup{}).Where("resolution = ?", resolution)
+}
+
+func (s gormStore) TimeRange(resolution int64) (first, last int64, ok bool, err error) {
+	var minTs, maxTs sql.NullInt64
+	err = s.level(resolution).Select("MIN(timestamp), MAX(timestamp)").Row().Scan(&minTs, &maxTs)
+	return minTs.Int64, maxTs.Int64, minTs.Valid, err
+}
+
+func (s gormStore) Nodes(resolution, start, end int64) ([]string, error) {
+	var nodes []string
+	err := s.level(resolution).Where("timestamp >= ? AND timestamp <= ?", start, end).
+		Distinct("node_id").Order("node_id").Pluck("node_id", &nodes).Error
+	return nodes, err
+}
+
+func (s gormStore) LoadRollups(nodeId string, resolution int64, agg string, start, end int64, limit int) ([]define.MeasureRollup, error) {
+	var rollups []define.MeasureRollup
+	query := s.conn().Where("node_id = ? AND resolution = ? AND timestamp >= ? AND timestamp <= ?", nodeId, resolution, start, end)
+	if agg != "" {
+		query = query.Where("agg = ?", agg)
+	}
+	err := limitRows(query, limit).Order("timestamp desc").Find(&rollups).Error
+	return rollups, err
+}
+
+func (s gormStore) SaveRollups(rollups []define.MeasureRollup) error {
+	// postgres needs the conflict target, it is the unique index
+	return s.conn().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}, {Name: "resolution"}, {Name: "timestamp"}, {Name: "agg"}},
+		UpdateAll: true,
+	}).CreateInBatches(rollups, 100).Error
+}
+
+func (s gormStore) DeleteRollups(resolution, before int64) error {
+	return s.conn().Where("resolution = ? AND timestamp < ?", resolution, before).
+		Delete(&define.MeasureRollup{}).Error
+}
+
+func (s gormStore) AddTraffic(l *define.TrafficLedger) error {
+	return s.conn().Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "node_id"}, {Name: "cycle_start"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			// qualified so postgres reads the stored row, not the new one
+			"net_send": gorm.Expr("traffic_ledgers.net_send + ?", l.NetSend),
+			"net_recv": gorm.Expr("traffic_ledgers.net_recv + ?", l.NetRecv),
+		}),
+	}).Create(l).Error
+}
+
+func (s gormStore) HasTraffic() (bool, error) {
+	var count int64
+	err := s.conn().Model(&define.TrafficLedger{}).Count(&count).Error
+	return count > 0, err
+}
+
+func (s gormStore) LoadTraffic(nodeId string, now int64) ([]define.TrafficCalcResult, error) {
+	var results []define.TrafficCalcResult
+	query := s.conn().Model(&define.TrafficLedger{}).
+		Select("node_id, net_send, net_recv").
+		Where("cycle_start <= ? AND cycle_end > ?", now, now)
+	if nodeId != "" {
+		query = query.Where("node_id = ?", nodeId)
+	}
+	err := query.Find(&results).Error
+	return results, err
+}
+
+func (s gormStore) LoadCycles(nodeId string) ([]define.TrafficLedger, error) {
+	var results []define.TrafficLedger
+	err := s.conn().Where("node_id = ?", nodeId).Order("cycle_start desc").Find(&results).Error
+	return results, err
+}
+
+func (s gormStore) InsertEvent(e *define.NodeEvent) error {
+	return s.conn().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}, {Name: "timestamp"}, {Name: "type"}},
+		DoNothing: true,
+	}).Create(e).Error
+}
+
+func (s gormStore) LoadEvents(nodeId string, start, end int64, limit int) ([]define.NodeEvent, error) {
+	var events []define.NodeEvent
+	err := limitRows(s.conn().Where("node_id = ? AND timestamp >= ? AND timestamp <= ?", nodeId, start, end), limit).
+		Order("timestamp desc").Find(&events).Error
+	return events, err
+}
+
+func limitRows(query *gorm.DB, limit int) *gorm.DB {
+	if limit > 0 {
+		return query.Limit(limit)
+	}
+	return query
+}
@@ -2,18 +2,63 @@ package record
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/zjyl1994/cloudstatus/infra/define"
 	"github.com/zjyl1994/cloudstatus/infra/vars"
-	"gorm.io/gorm"
 )
 
+// ErrBadRecord is returned for a report that cannot be stored, retrying it
+// does not help.
+var ErrBadRecord = errors.New("bad record")
+
+// maxNodeIDLen is the size of the node id columns.
+const maxNodeIDLen = 128
+
+// WriteRecord stores a report. Once the writer is started the record is
+// queued and written by the next flush, see StartWriter.
 func WriteRecord(def *define.StatExchangeFormat) error {
+	measure, err := newMeasureRecord(def)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRecord, err)
+	}
+	return writeRecords([]define.MeasureRecord{measure})
+}
+
+// WriteRecords stores the reports of a batch, either all of them are
+// queued or none. Reports that cannot be stored are dropped, they would
+// fail the batch on every retry.
+func WriteRecords(defs []define.StatExchangeFormat) error {
+	records := make([]define.MeasureRecord, 0, len(defs))
+	for i := range defs {
+		measure, err := newMeasureRecord(&defs[i])
+		if err != nil {
+			slog.Warn("Drop record", slog.String("node", defs[i].NodeID), slog.String("err", err.Error()))
+			continue
+		}
+		records = append(records, measure)
+	}
+	return writeRecords(records)
+}
+
+func newMeasureRecord(def *define.StatExchangeFormat) (define.MeasureRecord, error) {
 	var measure define.MeasureRecord
 	measure.NodeID = def.NodeID
 	measure.Timestamp = def.ReportTime
 	measure.CPU = def.Percent.CPU
+	measure.CPUUser = def.CPU.User
+	measure.CPUSystem = def.CPU.System
+	measure.CPUNice = def.CPU.Nice
+	measure.CPUIowait = def.CPU.Iowait
+	measure.CPUIrq = def.CPU.Irq
+	measure.CPUSoftirq = def.CPU.Softirq
+	measure.CPUSteal = def.CPU.Steal
 	measure.Memory = def.Percent.Mem
 	measure.Swap = def.Percent.Swap
 	measure.Disk = def.Percent.Disk
@@ -29,52 +74,141 @@ func WriteRecord(def *define.StatExchangeFormat) error {
 
 	tempJson, err := json.Marshal(def.Temperature)
 	if err != nil {
-		return err
+		return measure, err
 	}
 	measure.Temperature = string(tempJson)
 
-	return vars.DB.Create(&measure).Error
+	cores := make(map[string]float64, len(def.CPU.Cores))
+	for i, v := range def.CPU.Cores {
+		cores[strconv.Itoa(i)] = v
+	}
+	coresJson, err := json.Marshal(cores)
+	if err != nil {
+		return measure, err
+	}
+	measure.CPUCores = string(coresJson)
+
+	mounts := make(map[string]float64, len(def.Disk.Mounts))
+	for _, m := range def.Disk.Mounts {
+		mounts[m.Path] = m.Percent
+	}
+	mountsJson, err := json.Marshal(mounts)
+	if err != nil {
+		return measure, err
+	}
+	measure.Mounts = string(mountsJson)
+
+	// interface series are flattened to "<interface>/<counter>" keys
+	interfaces := make(map[string]float64, len(def.Network.Interfaces)*len(InterfaceCounters))
+	for _, v := range def.Network.Interfaces {
+		for _, counter := range InterfaceCounters {
+			interfaces[v.Name+"/"+counter] = float64(interfaceCounter(v, counter))
+		}
+	}
+	interfacesJson, err := json.Marshal(interfaces)
+	if err != nil {
+		return measure, err
+	}
+	measure.Interfaces = string(interfacesJson)
+
+	// sensor series are keyed "<type>/<chip>/<label>"
+	sensors := make(map[string]float64, len(def.Sensors))
+	for _, r := range def.Sensors {
+		sensors[r.Type+"/"+r.Name] = r.Value
+	}
+	sensorsJson, err := json.Marshal(sensors)
+	if err != nil {
+		return measure, err
+	}
+	measure.Sensors = string(sensorsJson)
+	return measure, checkRecord(&measure)
 }
 
-func GetNetTraffic() ([]define.TrafficCalcResult, error) {
-	var results []define.TrafficCalcResult
-	err := vars.DB.Model(&define.MeasureRecord{}).
-		Select("node_id,SUM(net_send) AS net_send, SUM(net_recv) AS net_recv").
-		Group("node_id").
-		Find(&results).Error
-	return results, err
+// checkRecord rejects values some database backend does not store. The
+// writer retries a failed batch until it is stored, so a queued record
+// must not fail on its own.
+func checkRecord(m *define.MeasureRecord) error {
+	if len(m.NodeID) > maxNodeIDLen {
+		return fmt.Errorf("node id longer than %d bytes", maxNodeIDLen)
+	}
+	v := reflect.ValueOf(*m)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct {
+			if err := checkValues(f); err != nil {
+				return err
+			}
+		}
+	}
+	return checkValues(v)
+}
+
+func checkValues(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Float64:
+			if math.IsNaN(f.Float()) || math.IsInf(f.Float(), 0) {
+				return fmt.Errorf("%s is not a finite number", v.Type().Field(i).Name)
+			}
+		case reflect.Uint64:
+			// stored as signed 64 bit integers
+			if f.Uint() > math.MaxInt64 {
+				return fmt.Errorf("%s out of range", v.Type().Field(i).Name)
+			}
+		}
+	}
+	return nil
 }
 
 func CleanRecord() error {
+	cfg := vars.Config.Load()
 	validNodeMap := make(map[string]struct{})
-	for _, node := range vars.Config.Nodes {
+	for _, node := range cfg.Nodes {
 		validNodeMap[node.ID] = struct{}{}
 	}
 	validNodes := make([]string, 0, len(validNodeMap))
 	for node := range validNodeMap {
 		validNodes = append(validNodes, node)
 	}
-	currentDayInMonth := time.Now().Day()
-	return vars.DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Where("node_id NOT IN ?", validNodes).Delete(&define.MeasureRecord{}).Error
-		if err != nil {
+	var before int64
+	if days := cfg.Retention.Raw; days > 0 {
+		before = time.Now().Unix() - int64(days)*86400
+	}
+	return store.Transaction(func(tx Store) error {
+		if err := tx.DeleteRecords(validNodes, before); err != nil {
 			return err
 		}
-		for _, node := range vars.Config.Nodes {
-			if node.ResetDay == currentDayInMonth {
-				err = tx.Where("node_id = ?", node.ID).Delete(&define.MeasureRecord{}).Error
-				if err != nil {
-					return err
-				}
-			}
-		}
-		return nil
+		return cleanRollup(tx)
 	})
 }
 
+// LoadRecord loads the raw records of a node, newest first.
 func LoadRecord(nodeId string, startTime, endTime int64) ([]define.MeasureRecord, error) {
-	var records []define.MeasureRecord
-	err := vars.DB.Where("node_id = ? AND timestamp >= ? AND timestamp <= ?", nodeId, startTime, endTime).
-		Order("timestamp desc").Limit(10000).Find(&records).Error
-	return records, err
+	return store.LoadRecords(nodeId, startTime, endTime, maxLoadRows)
+}
+
+// InterfaceCounters are the per interface series kept in records.
+var InterfaceCounters = []string{"rx", "tx", "packets_recv", "packets_sent", "err_in", "err_out", "drop_in", "drop_out"}
+
+func interfaceCounter(v define.InterfaceStat, counter string) uint64 {
+	switch counter {
+	case "rx":
+		return v.Rx
+	case "tx":
+		return v.Tx
+	case "packets_recv":
+		return v.PacketsRecv
+	case "packets_sent":
+		return v.PacketsSent
+	case "err_in":
+		return v.ErrIn
+	case "err_out":
+		return v.ErrOut
+	case "drop_in":
+		return v.DropIn
+	case "drop_out":
+		return v.DropOut
+	}
+	return 0
 }
@@ -0,0 +1,416 @@
+package record
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/storage"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"gorm.io/gorm"
+)
+
+// The tests run against a temporary sqlite file, and against the database
+// of CLOUDSTATUS_TEST_DSN when it is set. CLOUDSTATUS_TEST_DRIVER names its
+// driver, postgres by default. That database must be a scratch one, its
+// tables are emptied.
+const (
+	testDSNEnv    = "CLOUDSTATUS_TEST_DSN"
+	testDriverEnv = "CLOUDSTATUS_TEST_DRIVER"
+)
+
+// forEachBackend runs fn once per test database, with vars.DB, a gorm
+// store of it and a config of nodes n1 and n2 set up.
+func forEachBackend(t *testing.T, fn func(t *testing.T)) {
+	t.Run("sqlite", func(t *testing.T) {
+		useDB(t, storage.DefaultDriver, filepath.Join(t.TempDir(), "test.db"))
+		fn(t)
+	})
+	t.Run("dsn", func(t *testing.T) {
+		dsn := os.Getenv(testDSNEnv)
+		if dsn == "" {
+			t.Skip(testDSNEnv + " not set")
+		}
+		driver := os.Getenv(testDriverEnv)
+		if driver == "" {
+			driver = "postgres"
+		}
+		useDB(t, driver, dsn)
+		fn(t)
+	})
+}
+
+func useDB(t *testing.T, driver, dsn string) {
+	db, err := storage.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyTables(t, db)
+	oldDB, oldStore, oldConfig := vars.DB, store, vars.Config.Load()
+	vars.DB = db
+	SetStore(NewGormStore(db))
+	vars.Config.Store(&define.ServerConfig{
+		Nodes:    []define.ServerNode{{ID: "n1"}, {ID: "n2"}},
+		Location: time.UTC,
+	})
+	lateFrom, rolledTo = -1, -1
+	t.Cleanup(func() {
+		emptyTables(t, db)
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		vars.DB = oldDB
+		SetStore(oldStore)
+		vars.Config.Store(oldConfig)
+	})
+}
+
+func emptyTables(t *testing.T, db *gorm.DB) {
+	for _, model := range storage.Models {
+		if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(model).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testStat(nodeId string, ts int64, cpu float64, send uint64) define.StatExchangeFormat {
+	stat := define.StatExchangeFormat{NodeID: nodeId, ReportTime: ts}
+	stat.Percent.CPU = cpu
+	stat.Network.Send = send
+	stat.Network.Recv = 2 * send
+	stat.Disk.Mounts = []define.MountStat{{Path: "/", Percent: 50}}
+	return stat
+}
+
+// testBase is a day start two days ago, its buckets are all complete.
+func testBase() int64 {
+	return (time.Now().Unix()/86400 - 2) * 86400
+}
+
+func TestWriteAndLoadRecord(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		base := testBase()
+		for i := int64(0); i < 3; i++ {
+			stat := testStat("n1", base+i*60, float64(10*(i+1)), 100)
+			if err := WriteRecord(&stat); err != nil {
+				t.Fatal(err)
+			}
+		}
+		records, err := LoadRecord("n1", base+60, base+120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 || records[0].Timestamp != base+120 || records[0].CPU != 30 || records[1].CPU != 20 {
+			t.Fatalf("got %+v, want the last two records newest first", records)
+		}
+		if records[0].Mounts != `{"/":50}` {
+			t.Errorf("mounts = %s", records[0].Mounts)
+		}
+	})
+}
+
+func TestWriteRecordsReplay(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		base := testBase()
+		batch := []define.StatExchangeFormat{
+			testStat("n1", base, 10, 100),
+			testStat("n1", base+60, 20, 100),
+			// a report repeated inside the batch
+			testStat("n1", base+60, 20, 100),
+		}
+		// the retry of a batch that was stored
+		for range 2 {
+			if err := WriteRecords(batch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		records, err := LoadRecord("n1", 0, base+86400)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 {
+			t.Errorf("got %d records, want 2", len(records))
+		}
+		cycles, err := ListTrafficCycles("n1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cycles) != 1 || cycles[0].NetSend != 200 || cycles[0].NetRecv != 400 {
+			t.Errorf("got cycles %+v, want 200/400 once", cycles)
+		}
+
+		// a record stored by another write meanwhile is skipped, the rest
+		// of the batch is stored
+		concurrent, err := newMeasureRecord(&define.StatExchangeFormat{NodeID: "n1", ReportTime: base + 120})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = vars.DB.Create(&concurrent).Error; err != nil {
+			t.Fatal(err)
+		}
+		batch = []define.StatExchangeFormat{testStat("n1", base+120, 30, 100), testStat("n1", base+180, 40, 100)}
+		if err = WriteRecords(batch); err != nil {
+			t.Fatal(err)
+		}
+		if cycles, err = ListTrafficCycles("n1"); err != nil {
+			t.Fatal(err)
+		}
+		if len(cycles) != 1 || cycles[0].NetSend != 300 {
+			t.Errorf("got cycles %+v, want 300 sent", cycles)
+		}
+	})
+}
+
+func TestWriteRecordBad(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		stat := testStat("n1", testBase(), 10, 0)
+		stat.Network.Rx = 1 << 63
+		if err := WriteRecord(&stat); !errors.Is(err, ErrBadRecord) {
+			t.Errorf("got %v, want a bad record", err)
+		}
+		stat = testStat("n1", testBase(), math.NaN(), 0)
+		if err := WriteRecord(&stat); !errors.Is(err, ErrBadRecord) {
+			t.Errorf("got %v, want a bad record", err)
+		}
+	})
+}
+
+func TestWriter(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		base := testBase()
+		StartWriter(WriterOptions{QueueSize: 10, BatchSize: 2, Interval: time.Hour})
+		for i := int64(0); i < 5; i++ {
+			stat := testStat("n1", base+i*60, 10, 1)
+			if err := WriteRecord(&stat); err != nil {
+				StopWriter()
+				t.Fatal(err)
+			}
+		}
+		// stopping flushes whatever is still queued
+		StopWriter()
+		records, err := LoadRecord("n1", 0, base+86400)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 5 {
+			t.Errorf("got %d records, want 5", len(records))
+		}
+	})
+}
+
+func TestCleanRecord(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		cfg := *vars.Config.Load()
+		cfg.Retention = define.RetentionConfig{Raw: 1}
+		vars.Config.Store(&cfg)
+
+		now := time.Now().Unix()
+		stats := []define.StatExchangeFormat{
+			testStat("n1", now-3*86400, 10, 0),
+			testStat("n1", now-60, 20, 0),
+			testStat("gone", now-60, 30, 0),
+		}
+		if err := WriteRecords(stats); err != nil {
+			t.Fatal(err)
+		}
+		if err := CleanRecord(); err != nil {
+			t.Fatal(err)
+		}
+		var records []define.MeasureRecord
+		if err := vars.DB.Find(&records).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].NodeID != "n1" || records[0].CPU != 20 {
+			t.Errorf("got %+v, want the recent n1 record only", records)
+		}
+	})
+}
+
+func TestRollup(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		base := testBase()
+		var stats []define.StatExchangeFormat
+		for i := int64(0); i < 5; i++ {
+			stats = append(stats, testStat("n1", base+i*60, float64(10*(i+1)), 0))
+		}
+		stats = append(stats, testStat("n1", base+300, 100, 0))
+		if err := WriteRecords(stats); err != nil {
+			t.Fatal(err)
+		}
+		if err := RunRollup(); err != nil {
+			t.Fatal(err)
+		}
+		checkRollup(t, 300, AggAvg, base, 30, 5)
+		checkRollup(t, 300, AggMin, base, 10, 5)
+		checkRollup(t, 300, AggMax, base, 50, 5)
+
+		// a late record rolls its bucket up again, at every level
+		late := testStat("n1", base+270, 1000, 0)
+		if err := WriteRecord(&late); err != nil {
+			t.Fatal(err)
+		}
+		if err := RunRollup(); err != nil {
+			t.Fatal(err)
+		}
+		checkRollup(t, 300, AggMax, base, 1000, 6)
+		checkRollup(t, 3600, AggMax, base, 1000, 7)
+		checkRollup(t, 86400, AggMin, base, 10, 7)
+
+		// a record after the rolled up buckets is not marked late
+		recent := testStat("n1", time.Now().Unix(), 10, 0)
+		if err := WriteRecord(&recent); err != nil {
+			t.Fatal(err)
+		}
+		if lateFrom != -1 {
+			t.Errorf("late from %d, want no mark", lateFrom)
+		}
+	})
+}
+
+func checkRollup(t *testing.T, resolution int64, agg string, ts int64, cpu float64, samples int64) {
+	t.Helper()
+	rollups, err := LoadRollup("n1", resolution, agg, ts, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 1 {
+		t.Fatalf("%d %s: got %d rollups, want 1", resolution, agg, len(rollups))
+	}
+	if r := rollups[0]; r.CPU != cpu || r.Samples != samples {
+		t.Errorf("%d %s: got cpu %v of %d samples, want %v of %d", resolution, agg, r.CPU, r.Samples, cpu, samples)
+	}
+}
+
+func TestLoadSeriesWeighted(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		base := testBase()
+		// six samples in the first bucket and one in the second
+		var stats []define.StatExchangeFormat
+		for i, cpu := range []float64{10, 20, 30, 40, 50, 1000} {
+			stats = append(stats, testStat("n1", base+int64(i)*45, cpu, 0))
+		}
+		stats = append(stats, testStat("n1", base+300, 100, 0))
+		if err := WriteRecords(stats); err != nil {
+			t.Fatal(err)
+		}
+		if err := RunRollup(); err != nil {
+			t.Fatal(err)
+		}
+		resolution, records, err := LoadSeries("n1", base, base+599, 600, AggAvg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolution != 300 {
+			t.Fatalf("read resolution %d, want 300", resolution)
+		}
+		want := 1250.0 / 7
+		if len(records) != 1 || records[0].Timestamp != base || !almostEqual(records[0].CPU, want) {
+			t.Errorf("got %+v, want cpu %v at %d", records, want, base)
+		}
+	})
+}
+
+func TestRebootEvent(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		stat := testStat("n1", testBase()+600, 10, 0)
+		stat.Host.Uptime = 300
+		// a retried report stores its reboot once
+		for range 2 {
+			if err := WriteRebootEvent(&stat); err != nil {
+				t.Fatal(err)
+			}
+		}
+		events, err := LoadEvent("n1", 0, stat.ReportTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].Timestamp != stat.ReportTime-300 {
+			t.Errorf("got %+v, want one reboot", events)
+		}
+	})
+}
+
+func almostEqual(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+func TestDetectReboot(t *testing.T) {
+	prev := testStat("n1", 1000, 10, 0)
+	prev.Host.Uptime = 500
+	cur := testStat("n1", 1060, 10, 0)
+	cur.Host.Uptime = 560
+	if DetectReboot(&prev, &cur) {
+		t.Error("same boot detected as a reboot")
+	}
+	cur.Host.Uptime = 30
+	if !DetectReboot(&prev, &cur) {
+		t.Error("boot time change not detected")
+	}
+	// the agent flag needs no previous report, such as after a server
+	// restart
+	cur.Host.Rebooted = true
+	if !DetectReboot(nil, &cur) {
+		t.Error("agent flag not detected")
+	}
+}
+
+func TestLoadSeriesFastAgent(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		base := testBase()
+		// an agent reporting every second fills more raw rows than a chart
+		// loads
+		stats := make([]define.StatExchangeFormat, 0, maxLoadRows+600)
+		for i := range int64(maxLoadRows + 600) {
+			stats = append(stats, testStat("n1", base+i, float64(i%100), 0))
+		}
+		if err := WriteRecords(stats); err != nil {
+			t.Fatal(err)
+		}
+		if err := RunRollup(); err != nil {
+			t.Fatal(err)
+		}
+		end := base + maxLoadRows + 599
+		resolution, records, err := LoadSeries("n1", base, end, 600, AggP95)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolution != Resolutions[0] {
+			t.Errorf("read resolution %d, want %d", resolution, Resolutions[0])
+		}
+		if want := (end-base)/600 + 1; int64(len(records)) != want || records[0].Timestamp != base {
+			t.Errorf("got %d points from %d, want %d from %d", len(records), records[0].Timestamp, want, base)
+		}
+	})
+}
+
+func TestInitTrafficLedger(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		base := testBase()
+		stats := []define.StatExchangeFormat{testStat("n1", base, 10, 100), testStat("n1", base+60, 10, 50)}
+		if err := WriteRecords(stats); err != nil {
+			t.Fatal(err)
+		}
+		// a database from before the ledger
+		err := vars.DB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&define.TrafficLedger{}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the second run finds the ledger filled
+		for range 2 {
+			if err = InitTrafficLedger(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cycles, err := ListTrafficCycles("n1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cycles) != 1 || cycles[0].NetSend != 150 || cycles[0].NetRecv != 300 {
+			t.Errorf("got cycles %+v, want 150/300", cycles)
+		}
+	})
+}
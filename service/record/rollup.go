@@ -0,0 +1,427 @@
+package record
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+)
+
+const (
+	AggAvg = "avg"
+	AggMin = "min"
+	AggMax = "max"
+
+	// rollupGrace keeps recent buckets open for late reports.
+	rollupGrace = 60
+	// maxChartPoints is the point budget used to pick a resolution.
+	maxChartPoints = 2880
+	// rawInterval is the assumed report interval of raw records.
+	rawInterval = 60
+	// maxLoadRows bounds the rows loaded for a chart, the newest are kept.
+	maxLoadRows = 10000
+)
+
+// Resolutions lists the rollup levels, each one built from the previous
+// level (raw records for the first one).
+var Resolutions = []int64{300, 3600, 86400}
+
+var (
+	lateLock sync.Mutex
+	// lateFrom is the oldest late record timestamp written since the last
+	// rollup run, -1 when nothing was written
+	lateFrom int64 = -1
+	// rolledTo is the end of the raw records read by the last rollup run,
+	// -1 until the first run
+	rolledTo int64 = -1
+)
+
+// markWritten notes the oldest timestamp of stored records, so the next
+// rollup run aggregates again the buckets they fall in. Reports arriving
+// after their bucket was rolled up, such as replayed agent backlogs, would
+// be missing from rollups otherwise. Records after the last rolled up
+// bucket are picked up by the next run anyway and are not marked.
+func markWritten(oldest int64) {
+	lateLock.Lock()
+	defer lateLock.Unlock()
+	// before the first run the rollups of a previous process are unknown
+	if rolledTo >= 0 && oldest >= rolledTo {
+		return
+	}
+	if lateFrom < 0 || oldest < lateFrom {
+		lateFrom = oldest
+	}
+}
+
+// RunRollup aggregates every completed bucket that is not rolled up yet,
+// and the buckets touched by records written since the last run.
+func RunRollup() error {
+	now := time.Now().Unix()
+	lateLock.Lock()
+	since := lateFrom
+	lateFrom = -1
+	// moved before any record is read, a record stored while the run
+	// reads its bucket is marked for the next run
+	rolledTo = max(rolledTo, (now-rollupGrace)/Resolutions[0]*Resolutions[0])
+	lateLock.Unlock()
+
+	var source int64
+	for _, resolution := range Resolutions {
+		if err := rollupLevel(source, resolution, now, since); err != nil {
+			// keep the mark for the next run
+			if since >= 0 {
+				markWritten(since)
+			}
+			return err
+		}
+		source = resolution
+	}
+	return nil
+}
+
+func rollupLevel(source, resolution, now, since int64) error {
+	end := (now - rollupGrace) / resolution * resolution
+
+	// continue after the newest bucket, or from the oldest source data
+	_, last, ok, err := store.TimeRange(resolution)
+	if err != nil {
+		return err
+	}
+	start := last + resolution
+	if ok && since >= 0 {
+		// upserts overwrite the buckets rolled up before the late records
+		start = min(start, since/resolution*resolution)
+	}
+	if !ok {
+		first, _, ok, err := store.TimeRange(source)
+		if err != nil || !ok {
+			return err
+		}
+		start = first / resolution * resolution
+	}
+
+	chunk := resolution * 288
+	for ; start < end; start += chunk {
+		chunkEnd := min(start+chunk, end)
+		// one node at a time, the rows of every node would not fit in
+		// memory on large fleets
+		nodes, err := store.Nodes(source, start, chunkEnd-1)
+		if err != nil {
+			return err
+		}
+		for _, nodeId := range nodes {
+			var rows []define.MeasureRollup
+			if source == 0 {
+				rows, err = rollupFromRecords(nodeId, resolution, start, chunkEnd)
+			} else {
+				rows, err = rollupFromRollups(nodeId, source, resolution, start, chunkEnd)
+			}
+			if err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				continue
+			}
+			if err = store.SaveRollups(rows); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type bucketKey struct {
+	nodeId    string
+	timestamp int64
+}
+
+func rollupFromRecords(nodeId string, resolution, start, end int64) ([]define.MeasureRollup, error) {
+	records, err := store.LoadRecords(nodeId, start, end-1, 0)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(records)
+	buckets := make(map[bucketKey]*valuesAgg)
+	var keys []bucketKey
+	for _, r := range records {
+		key := bucketKey{r.NodeID, r.Timestamp / resolution * resolution}
+		agg, ok := buckets[key]
+		if !ok {
+			agg = new(valuesAgg)
+			buckets[key] = agg
+			keys = append(keys, key)
+		}
+		agg.add(&r.MeasureValues, &r.MeasureValues, &r.MeasureValues, 1)
+	}
+	return buildRollups(resolution, keys, buckets), nil
+}
+
+func rollupFromRollups(nodeId string, source, resolution, start, end int64) ([]define.MeasureRollup, error) {
+	rollups, err := store.LoadRollups(nodeId, source, "", start, end-1, 0)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(rollups)
+	// group avg/min/max rows of each source bucket
+	type sourceBucket struct {
+		samples       int64
+		avg, min, max *define.MeasureValues
+	}
+	sources := make(map[bucketKey]*sourceBucket)
+	var sourceKeys []bucketKey
+	for i := range rollups {
+		r := &rollups[i]
+		key := bucketKey{r.NodeID, r.Timestamp}
+		sb, ok := sources[key]
+		if !ok {
+			sb = new(sourceBucket)
+			sources[key] = sb
+			sourceKeys = append(sourceKeys, key)
+		}
+		switch r.Agg {
+		case AggAvg:
+			sb.avg = &r.MeasureValues
+			sb.samples = r.Samples
+		case AggMin:
+			sb.min = &r.MeasureValues
+		case AggMax:
+			sb.max = &r.MeasureValues
+		}
+	}
+
+	buckets := make(map[bucketKey]*valuesAgg)
+	var keys []bucketKey
+	for _, sk := range sourceKeys {
+		sb := sources[sk]
+		if sb.avg == nil || sb.min == nil || sb.max == nil {
+			continue
+		}
+		key := bucketKey{sk.nodeId, sk.timestamp / resolution * resolution}
+		agg, ok := buckets[key]
+		if !ok {
+			agg = new(valuesAgg)
+			buckets[key] = agg
+			keys = append(keys, key)
+		}
+		agg.add(sb.avg, sb.min, sb.max, sb.samples)
+	}
+	return buildRollups(resolution, keys, buckets), nil
+}
+
+func buildRollups(resolution int64, keys []bucketKey, buckets map[bucketKey]*valuesAgg) []define.MeasureRollup {
+	rows := make([]define.MeasureRollup, 0, len(keys)*3)
+	for _, key := range keys {
+		agg := buckets[key]
+		avg, minValues, maxValues := agg.result()
+		for _, item := range []struct {
+			agg    string
+			values define.MeasureValues
+		}{{AggAvg, avg}, {AggMin, minValues}, {AggMax, maxValues}} {
+			rows = append(rows, define.MeasureRollup{
+				NodeID:        key.nodeId,
+				Resolution:    resolution,
+				Timestamp:     key.timestamp,
+				Agg:           item.agg,
+				Samples:       agg.samples,
+				MeasureValues: item.values,
+			})
+		}
+	}
+	return rows
+}
+
+// PickResolution returns the finest resolution that fits the time window
+// in the chart point budget and is still kept by retention, 0 means raw.
+// With a step it returns the coarsest such resolution not above step, as
+// finer points would only be bucketed again.
+func PickResolution(startTime, endTime, step int64) int64 {
+	now := time.Now().Unix()
+	span := endTime - startTime
+	retention := vars.Config.Load().Retention
+	levels := []struct {
+		resolution int64
+		days       int
+	}{
+		{0, retention.Raw},
+		{Resolutions[0], retention.Rollup5m},
+		{Resolutions[1], retention.Rollup1h},
+		{Resolutions[2], retention.Rollup1d},
+	}
+	picked := int64(-1)
+	for _, level := range levels {
+		if span/max(level.resolution, rawInterval) > maxChartPoints {
+			continue
+		}
+		if level.days > 0 && startTime < now-int64(level.days)*86400 {
+			continue
+		}
+		if picked >= 0 && level.resolution > step {
+			break
+		}
+		picked = level.resolution
+	}
+	if picked < 0 {
+		return Resolutions[len(Resolutions)-1]
+	}
+	return picked
+}
+
+// LoadRollup loads one aggregate of a rollup level, newest first.
+func LoadRollup(nodeId string, resolution int64, agg string, startTime, endTime int64) ([]define.MeasureRollup, error) {
+	return store.LoadRollups(nodeId, resolution, agg, startTime, endTime, maxLoadRows)
+}
+
+func cleanRollup(tx Store) error {
+	retention := vars.Config.Load().Retention
+	now := time.Now().Unix()
+	for i, days := range []int{retention.Rollup5m, retention.Rollup1h, retention.Rollup1d} {
+		if days <= 0 {
+			continue
+		}
+		if err := tx.DeleteRollups(Resolutions[i], now-int64(days)*86400); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valuesAgg aggregates MeasureValues field by field, numeric fields
+// directly and string fields as JSON encoded map[string]float64.
+type valuesAgg struct {
+	samples int64
+	nums    []numAgg
+	maps    []map[string]*numAgg
+}
+
+type numAgg struct {
+	weight   float64
+	sum      float64
+	min, max float64
+}
+
+func (n *numAgg) add(avg, minValue, maxValue float64, weight float64) {
+	if n.weight == 0 {
+		n.min, n.max = minValue, maxValue
+	} else {
+		n.min = math.Min(n.min, minValue)
+		n.max = math.Max(n.max, maxValue)
+	}
+	n.sum += avg * weight
+	n.weight += weight
+}
+
+var valuesType = reflect.TypeOf(define.MeasureValues{})
+
+func (a *valuesAgg) add(avg, minValues, maxValues *define.MeasureValues, samples int64) {
+	if a.nums == nil {
+		a.nums = make([]numAgg, valuesType.NumField())
+		a.maps = make([]map[string]*numAgg, valuesType.NumField())
+	}
+	a.samples += samples
+	weight := float64(samples)
+	av, mnv, mxv := reflect.ValueOf(avg).Elem(), reflect.ValueOf(minValues).Elem(), reflect.ValueOf(maxValues).Elem()
+	for i := 0; i < valuesType.NumField(); i++ {
+		if valuesType.Field(i).Type.Kind() == reflect.String {
+			avgMap, minMap, maxMap := decodeMap(av.Field(i)), decodeMap(mnv.Field(i)), decodeMap(mxv.Field(i))
+			if len(avgMap) == 0 {
+				continue
+			}
+			if a.maps[i] == nil {
+				a.maps[i] = make(map[string]*numAgg)
+			}
+			for k, v := range avgMap {
+				n, ok := a.maps[i][k]
+				if !ok {
+					n = new(numAgg)
+					a.maps[i][k] = n
+				}
+				minValue, ok := minMap[k]
+				if !ok {
+					minValue = v
+				}
+				maxValue, ok := maxMap[k]
+				if !ok {
+					maxValue = v
+				}
+				n.add(v, minValue, maxValue, weight)
+			}
+			continue
+		}
+		a.nums[i].add(toFloat(av.Field(i)), toFloat(mnv.Field(i)), toFloat(mxv.Field(i)), weight)
+	}
+}
+
+func (a *valuesAgg) result() (avg, minValues, maxValues define.MeasureValues) {
+	av, mnv, mxv := reflect.ValueOf(&avg).Elem(), reflect.ValueOf(&minValues).Elem(), reflect.ValueOf(&maxValues).Elem()
+	for i := 0; i < valuesType.NumField(); i++ {
+		if valuesType.Field(i).Type.Kind() == reflect.String {
+			avgMap := make(map[string]float64, len(a.maps[i]))
+			minMap := make(map[string]float64, len(a.maps[i]))
+			maxMap := make(map[string]float64, len(a.maps[i]))
+			for k, n := range a.maps[i] {
+				avgMap[k] = n.sum / n.weight
+				minMap[k] = n.min
+				maxMap[k] = n.max
+			}
+			encodeMap(av.Field(i), avgMap)
+			encodeMap(mnv.Field(i), minMap)
+			encodeMap(mxv.Field(i), maxMap)
+			continue
+		}
+		n := a.nums[i]
+		if n.weight == 0 {
+			continue
+		}
+		setFloat(av.Field(i), n.sum/n.weight)
+		setFloat(mnv.Field(i), n.min)
+		setFloat(mxv.Field(i), n.max)
+	}
+	return
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	}
+	return 0
+}
+
+func setFloat(v reflect.Value, f float64) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(f)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(math.Round(f)))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(math.Round(f)))
+	}
+}
+
+func decodeMap(v reflect.Value) map[string]float64 {
+	var m map[string]float64
+	if s := v.String(); s != "" {
+		_ = json.Unmarshal([]byte(s), &m)
+	}
+	return m
+}
+
+func encodeMap(v reflect.Value, m map[string]float64) {
+	if len(m) == 0 {
+		v.SetString("null")
+		return
+	}
+	b, err := json.Marshal(m)
+	if err == nil {
+		v.SetString(string(b))
+	}
+}
@@ -0,0 +1,64 @@
+package record
+
+import (
+	"database/sql"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+// Store is the database layer of the record package. It only moves rows,
+// bucketing, billing cycles and aggregation stay in the record package.
+// Time ranges are inclusive of both ends, lists are newest first, and a
+// limit of 0 returns every row.
+type Store interface {
+	// Transaction runs fn on a store whose writes are committed together
+	// when fn returns nil.
+	Transaction(fn func(tx Store) error) error
+
+	// InsertRecord stores r unless its node has a record at that second, it
+	// reports whether r was stored.
+	InsertRecord(r *define.MeasureRecord) (bool, error)
+	LoadRecords(nodeId string, start, end int64, limit int) ([]define.MeasureRecord, error)
+	// ScanTraffic calls fn with the node, time and traffic of every record.
+	ScanTraffic(fn func(r *define.MeasureRecord) error) error
+	// ExportRecords streams columns of the records of nodes ordered by node
+	// and time.
+	ExportRecords(columns, nodes []string, start, end int64) (*sql.Rows, error)
+	// DeleteRecords removes the records of nodes missing from keep, and
+	// every record before before when it is positive.
+	DeleteRecords(keep []string, before int64) error
+
+	// TimeRange returns the first and last timestamp of a rollup level,
+	// resolution 0 is raw records. ok is false when the level is empty.
+	TimeRange(resolution int64) (first, last int64, ok bool, err error)
+	// Nodes returns the nodes having data of a rollup level in the range,
+	// resolution 0 is raw records.
+	Nodes(resolution, start, end int64) ([]string, error)
+	// LoadRollups loads an aggregate of a rollup level, every aggregate
+	// when agg is empty.
+	LoadRollups(nodeId string, resolution int64, agg string, start, end int64, limit int) ([]define.MeasureRollup, error)
+	// SaveRollups stores rollups over the ones of the same bucket.
+	SaveRollups(rollups []define.MeasureRollup) error
+	DeleteRollups(resolution, before int64) error
+
+	// AddTraffic adds the traffic of l to the ledger of its cycle.
+	AddTraffic(l *define.TrafficLedger) error
+	HasTraffic() (bool, error)
+	// LoadTraffic returns the traffic of the cycles holding now, of every
+	// node when nodeId is empty.
+	LoadTraffic(nodeId string, now int64) ([]define.TrafficCalcResult, error)
+	LoadCycles(nodeId string) ([]define.TrafficLedger, error)
+
+	// InsertEvent stores e unless its node has the same event at that
+	// second.
+	InsertEvent(e *define.NodeEvent) error
+	LoadEvents(nodeId string, start, end int64, limit int) ([]define.NodeEvent, error)
+}
+
+// store is the gorm store of vars.DB unless replaced by SetStore.
+var store Store = gormStore{}
+
+// SetStore makes the record package keep its data in s.
+func SetStore(s Store) {
+	store = s
+}
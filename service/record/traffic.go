@@ -0,0 +1,179 @@
+package record
+
+import (
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+)
+
+// BillingCycle returns the billing cycle [start, end) containing t. Cycles
+// begin at 00:00 of resetDay in loc, clamped to the last day of short
+// months, a resetDay outside 1-31 means the first day of month.
+func BillingCycle(resetDay int, t time.Time, loc *time.Location) (time.Time, time.Time) {
+	if resetDay < 1 || resetDay > 31 {
+		resetDay = 1
+	}
+	t = t.In(loc)
+	year, month, _ := t.Date()
+	start := cycleAnchor(year, month, resetDay, loc)
+	if t.Before(start) {
+		return cycleAnchor(year, month-1, resetDay, loc), start
+	}
+	return start, cycleAnchor(year, month+1, resetDay, loc)
+}
+
+func cycleAnchor(year int, month time.Month, resetDay int, loc *time.Location) time.Time {
+	// day 0 of the next month is the last day of this month
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	return time.Date(year, month, min(resetDay, lastDay), 0, 0, 0, 0, loc)
+}
+
+func nodeResetDay(cfg *define.ServerConfig, nodeId string) int {
+	for _, node := range cfg.Nodes {
+		if node.ID == nodeId {
+			return node.ResetDay
+		}
+	}
+	return 0
+}
+
+// addTraffic adds the traffic of records to the ledgers of their cycles,
+// with one upsert per node and cycle.
+func addTraffic(tx Store, records []define.MeasureRecord) error {
+	type cycleKey struct {
+		nodeId string
+		start  int64
+	}
+	var keys []cycleKey
+	ledgers := make(map[cycleKey]*define.TrafficLedger)
+	cfg := vars.Config.Load()
+	for _, r := range records {
+		if r.NetSend == 0 && r.NetRecv == 0 {
+			continue
+		}
+		start, end := BillingCycle(nodeResetDay(cfg, r.NodeID), time.Unix(r.Timestamp, 0), cfg.Location)
+		key := cycleKey{r.NodeID, start.Unix()}
+		ledger, ok := ledgers[key]
+		if !ok {
+			ledger = &define.TrafficLedger{NodeID: r.NodeID, CycleStart: start.Unix(), CycleEnd: end.Unix()}
+			ledgers[key] = ledger
+			keys = append(keys, key)
+		}
+		ledger.NetSend += r.NetSend
+		ledger.NetRecv += r.NetRecv
+	}
+	for _, key := range keys {
+		if err := tx.AddTraffic(ledgers[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InitTrafficLedger fills an empty ledger from the raw records kept so far.
+func InitTrafficLedger() error {
+	ok, err := store.HasTraffic()
+	if err != nil || ok {
+		return err
+	}
+
+	type cycleKey struct {
+		nodeId string
+		start  int64
+	}
+	var keys []cycleKey
+	ledgers := make(map[cycleKey]*define.TrafficLedger)
+	cfg := vars.Config.Load()
+	err = store.ScanTraffic(func(r *define.MeasureRecord) error {
+		start, end := BillingCycle(nodeResetDay(cfg, r.NodeID), time.Unix(r.Timestamp, 0), cfg.Location)
+		key := cycleKey{r.NodeID, start.Unix()}
+		ledger, ok := ledgers[key]
+		if !ok {
+			ledger = &define.TrafficLedger{NodeID: r.NodeID, CycleStart: start.Unix(), CycleEnd: end.Unix()}
+			ledgers[key] = ledger
+			keys = append(keys, key)
+		}
+		ledger.NetSend += r.NetSend
+		ledger.NetRecv += r.NetRecv
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return store.Transaction(func(tx Store) error {
+		for _, key := range keys {
+			if err := tx.AddTraffic(ledgers[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetNetTraffic returns the traffic of every node in its current cycle.
+func GetNetTraffic() ([]define.TrafficCalcResult, error) {
+	return store.LoadTraffic("", time.Now().Unix())
+}
+
+// ListTrafficCycles returns every recorded cycle of a node, newest first.
+func ListTrafficCycles(nodeId string) ([]define.TrafficLedger, error) {
+	return store.LoadCycles(nodeId)
+}
+
+// minProjectionElapsed is how much of a cycle must pass before usage is
+// projected, the rate of the first hours says little about the month.
+const minProjectionElapsed = 24 * time.Hour
+
+// QuotaModes lists the accepted ServerNode.QuotaMode values, empty is sum.
+var QuotaModes = []string{"", "out", "in", "sum", "max"}
+
+// CalcQuota returns the quota usage of node for the traffic of its current
+// cycle, with the usage at cycle end projected from the average rate so
+// far. Until minProjectionElapsed has passed, or 5% of a longer cycle,
+// the projection is the usage itself. It returns nil for nodes without a
+// quota.
+func CalcQuota(node define.ServerNode, send, recv uint64, now time.Time) *define.QuotaUsage {
+	if node.Quota == 0 {
+		return nil
+	}
+	var used uint64
+	switch node.QuotaMode {
+	case "out":
+		used = send
+	case "in":
+		used = recv
+	case "max":
+		used = max(send, recv)
+	default:
+		used = send + recv
+	}
+
+	start, end := BillingCycle(node.ResetDay, now, vars.Config.Load().Location)
+	usage := &define.QuotaUsage{
+		Limit:      node.Quota,
+		Used:       used,
+		Percent:    float64(used) / float64(node.Quota) * 100,
+		Projected:  used,
+		CycleStart: start.Unix(),
+		CycleEnd:   end.Unix(),
+	}
+	if used < node.Quota {
+		usage.Remaining = node.Quota - used
+	}
+	cycle := end.Sub(start)
+	if elapsed := now.Sub(start); elapsed >= max(minProjectionElapsed, cycle/20) {
+		usage.Projected = uint64(float64(used) * float64(cycle) / float64(elapsed))
+	}
+	usage.ProjectedPercent = float64(usage.Projected) / float64(node.Quota) * 100
+	return usage
+}
+
+// GetNodeTraffic returns the traffic of one node in its current cycle.
+func GetNodeTraffic(nodeId string) (define.TrafficCalcResult, error) {
+	results, err := store.LoadTraffic(nodeId, time.Now().Unix())
+	if err != nil || len(results) == 0 {
+		return define.TrafficCalcResult{NodeId: nodeId}, err
+	}
+	return results[0], nil
+}
@@ -0,0 +1,325 @@
+package record
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+// maxRetryDelay bounds the wait between attempts to write a failed batch.
+const maxRetryDelay = time.Minute
+
+// ErrQueueFull is returned when the write queue stayed full for the whole
+// enqueue timeout, the reporter should retry later.
+var ErrQueueFull = errors.New("record write queue full")
+
+// ErrWriterStopped is returned for records arriving after shutdown began.
+var ErrWriterStopped = errors.New("record writer stopped")
+
+// WriterOptions configures the record writer.
+type WriterOptions struct {
+	// QueueSize is the most records waiting to be written.
+	QueueSize int
+	// BatchSize is the most records written in one transaction, a queue
+	// holding that many records is flushed at once.
+	BatchSize int
+	// Interval is the longest time a record waits for a flush.
+	Interval time.Duration
+	// EnqueueTimeout is how long a writer waits for room in a full queue.
+	EnqueueTimeout time.Duration
+}
+
+// WriterStat is a snapshot of the writer for the metrics endpoint.
+type WriterStat struct {
+	Running  bool
+	Depth    int
+	Capacity int
+	Flushes  uint64
+	Written  uint64
+	// Retries counts failed flushes, their batch is written again later.
+	Retries uint64
+	// Failed counts records still queued when the writer stopped with a
+	// failing database.
+	Failed    uint64
+	Rejected  uint64
+	LastBatch int
+	LastFlush time.Duration
+}
+
+// recordWriter queues records in memory and writes them in batched
+// transactions, so reports do not wait for database locks.
+type recordWriter struct {
+	opts  WriterOptions
+	lock  sync.Mutex
+	queue []define.MeasureRecord
+	// space is closed and replaced after every flush to wake blocked
+	// writers
+	space  chan struct{}
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	closed bool
+	stat   WriterStat
+}
+
+var (
+	writerLock sync.Mutex
+	writer     *recordWriter
+)
+
+// StartWriter starts the background writer, records are written
+// synchronously until it is started.
+func StartWriter(opts WriterOptions) {
+	opts.QueueSize = max(opts.QueueSize, 1)
+	opts.BatchSize = min(max(opts.BatchSize, 1), opts.QueueSize)
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.EnqueueTimeout <= 0 {
+		opts.EnqueueTimeout = 5 * time.Second
+	}
+	w := &recordWriter{
+		opts:   opts,
+		space:  make(chan struct{}),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	w.stat.Running = true
+	w.stat.Capacity = opts.QueueSize
+
+	writerLock.Lock()
+	writer = w
+	writerLock.Unlock()
+	go w.run()
+}
+
+// StopWriter flushes the queued records and stops the writer, call it
+// before closing the database.
+func StopWriter() {
+	writerLock.Lock()
+	w := writer
+	writer = nil
+	writerLock.Unlock()
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	w.closed = true
+	close(w.space)
+	w.space = make(chan struct{})
+	w.lock.Unlock()
+	close(w.stop)
+	<-w.done
+}
+
+// GetWriterStat returns the state of the writer.
+func GetWriterStat() WriterStat {
+	writerLock.Lock()
+	w := writer
+	writerLock.Unlock()
+	if w == nil {
+		return WriterStat{}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	stat := w.stat
+	stat.Depth = len(w.queue)
+	return stat
+}
+
+func writeRecords(records []define.MeasureRecord) error {
+	writerLock.Lock()
+	w := writer
+	writerLock.Unlock()
+	if w == nil {
+		return writeBatch(records)
+	}
+	return w.enqueue(records)
+}
+
+// enqueue adds records to the queue, waiting while it has no room for all
+// of them. A batch larger than the queue is taken when the queue is empty.
+func (w *recordWriter) enqueue(records []define.MeasureRecord) error {
+	timeout := time.NewTimer(w.opts.EnqueueTimeout)
+	defer timeout.Stop()
+	for {
+		w.lock.Lock()
+		if w.closed {
+			w.lock.Unlock()
+			return ErrWriterStopped
+		}
+		if len(w.queue) == 0 || len(w.queue)+len(records) <= w.opts.QueueSize {
+			w.queue = append(w.queue, records...)
+			full := len(w.queue) >= w.opts.BatchSize
+			w.lock.Unlock()
+			if full {
+				select {
+				case w.notify <- struct{}{}:
+				default:
+				}
+			}
+			return nil
+		}
+		space := w.space
+		w.lock.Unlock()
+
+		select {
+		case <-space:
+		case <-timeout.C:
+			w.lock.Lock()
+			w.stat.Rejected += uint64(len(records))
+			w.lock.Unlock()
+			return ErrQueueFull
+		}
+	}
+}
+
+// run flushes the queue on every tick or full batch. After a failed flush
+// it waits with a doubling delay instead, while the queue fills up and
+// reporters are told to retry.
+func (w *recordWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	var delay time.Duration
+	for {
+		if delay > 0 {
+			retry := time.NewTimer(delay)
+			select {
+			case <-retry.C:
+			case <-w.stop:
+				retry.Stop()
+				w.flushLast()
+				return
+			}
+		} else {
+			select {
+			case <-w.notify:
+			case <-ticker.C:
+			case <-w.stop:
+				w.flushLast()
+				return
+			}
+		}
+		if w.flush() {
+			delay = 0
+		} else {
+			delay = min(max(2*delay, w.opts.Interval), maxRetryDelay)
+		}
+	}
+}
+
+// flushLast flushes the queue at shutdown, the records are lost when the
+// database still fails.
+func (w *recordWriter) flushLast() {
+	if w.flush() {
+		return
+	}
+	w.lock.Lock()
+	lost := len(w.queue)
+	w.queue = nil
+	w.stat.Failed += uint64(lost)
+	w.lock.Unlock()
+	slog.Error("Drop queued records", slog.Int("count", lost))
+}
+
+// flush writes the queue in batches of BatchSize until it is empty. A batch
+// failing to write stays at the queue head, flush reports false and the
+// batch is written again by a later flush.
+func (w *recordWriter) flush() bool {
+	for {
+		w.lock.Lock()
+		n := min(len(w.queue), w.opts.BatchSize)
+		if n == 0 {
+			w.lock.Unlock()
+			return true
+		}
+		batch := w.queue[:n]
+		w.lock.Unlock()
+
+		start := time.Now()
+		err := writeBatch(batch)
+		elapsed := time.Since(start)
+		if err != nil {
+			slog.Error("Write record batch", slog.Int("count", n), slog.String("err", err.Error()))
+			w.lock.Lock()
+			w.stat.Retries++
+			w.lock.Unlock()
+			return false
+		}
+
+		w.lock.Lock()
+		// enqueue only appends, so the batch is still the queue head
+		w.queue = w.queue[n:]
+		if len(w.queue) == 0 {
+			w.queue = nil
+		}
+		w.stat.Flushes++
+		w.stat.Written += uint64(n)
+		w.stat.LastBatch = n
+		w.stat.LastFlush = elapsed
+		close(w.space)
+		w.space = make(chan struct{})
+		w.lock.Unlock()
+	}
+}
+
+// writeBatch stores records and their traffic in one transaction. A record
+// whose node already has one at that second is a replayed report, it is
+// skipped and its traffic is not counted again.
+func writeBatch(records []define.MeasureRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var inserted []define.MeasureRecord
+	err := store.Transaction(func(tx Store) error {
+		inserted = inserted[:0]
+		for _, r := range uniqueRecords(records) {
+			ok, err := tx.InsertRecord(&r)
+			if err != nil {
+				return err
+			}
+			if ok {
+				inserted = append(inserted, r)
+			}
+		}
+		return addTraffic(tx, inserted)
+	})
+	if err != nil || len(inserted) == 0 {
+		return err
+	}
+	oldest := inserted[0].Timestamp
+	for _, r := range inserted[1:] {
+		oldest = min(oldest, r.Timestamp)
+	}
+	markWritten(oldest)
+	return nil
+}
+
+type recordKey struct {
+	NodeID    string
+	Timestamp int64
+}
+
+// uniqueRecords returns copies of the records keeping the first of those
+// repeated in the batch. The copies are created on fresh rows, ids gorm
+// writes back from a rolled back insert would collide when the records
+// are retried.
+func uniqueRecords(records []define.MeasureRecord) []define.MeasureRecord {
+	seen := make(map[recordKey]bool, len(records))
+	rows := make([]define.MeasureRecord, 0, len(records))
+	for _, r := range records {
+		k := recordKey{r.NodeID, r.Timestamp}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		r.ID = 0
+		rows = append(rows, r)
+	}
+	return rows
+}
@@ -0,0 +1,116 @@
+package register
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+	"github.com/zjyl1994/cloudstatus/infra/vars"
+	"gorm.io/gorm"
+)
+
+// ErrSecretMismatch is returned for a request repeating a registration
+// without its secret, another agent enrolled the node first.
+var ErrSecretMismatch = errors.New("registration secret mismatch")
+
+// ErrNotPending is returned when approving a registration that is no
+// longer pending.
+var ErrNotPending = errors.New("registration not pending")
+
+// CheckSecret reports whether secret is the one node enrolled with.
+func CheckSecret(node define.PendingNode, secret string) bool {
+	digest := secretDigest(secret)
+	return subtle.ConstantTimeCompare([]byte(node.SecretDigest), []byte(digest)) == 1
+}
+
+func secretDigest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Touch records a registration attempt, a new agent becomes pending and a
+// known one gets its host details and last seen time refreshed.
+func Touch(req define.RegisterRequest, address string) (define.PendingNode, error) {
+	now := time.Now().Unix()
+	var node define.PendingNode
+	err := vars.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("node_id = ?", req.NodeID).First(&node).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			node = define.PendingNode{
+				NodeID:       req.NodeID,
+				Status:       define.PendingStatusPending,
+				SecretDigest: secretDigest(req.Secret),
+				FirstSeen:    now,
+			}
+		} else if err != nil {
+			return err
+		} else if !CheckSecret(node, req.Secret) {
+			return ErrSecretMismatch
+		}
+		node.Hostname = req.Hostname
+		node.Platform = req.Platform
+		node.Version = req.Version
+		node.Arch = req.Arch
+		node.Address = address
+		node.LastSeen = now
+		return tx.Save(&node).Error
+	})
+	return node, err
+}
+
+// Get returns the registration of nodeId.
+func Get(nodeId string) (define.PendingNode, error) {
+	var node define.PendingNode
+	err := vars.DB.Where("node_id = ?", nodeId).First(&node).Error
+	return node, err
+}
+
+// List returns every registration, newest first.
+func List() ([]define.PendingNode, error) {
+	var nodes []define.PendingNode
+	err := vars.DB.Order("last_seen desc").Find(&nodes).Error
+	return nodes, err
+}
+
+// Approve marks the pending nodeId approved, its agent can pick up a node
+// token from then on.
+func Approve(nodeId string) error {
+	result := vars.DB.Model(&define.PendingNode{}).
+		Where("node_id = ? AND status = ?", nodeId, define.PendingStatusPending).
+		Update("status", define.PendingStatusApproved)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotPending
+	}
+	return nil
+}
+
+// Revert takes back the approval of nodeId, for when adding the node failed.
+func Revert(nodeId string) error {
+	return vars.DB.Model(&define.PendingNode{}).
+		Where("node_id = ? AND status = ?", nodeId, define.PendingStatusApproved).
+		Update("status", define.PendingStatusPending).Error
+}
+
+// Done forgets the approved registration of nodeId once its agent reported
+// with the issued token.
+func Done(nodeId string) error {
+	return vars.DB.Where("node_id = ? AND status = ?", nodeId, define.PendingStatusApproved).
+		Delete(&define.PendingNode{}).Error
+}
+
+// Reject marks nodeId rejected, its agent is refused until deleted.
+func Reject(nodeId string) error {
+	return vars.DB.Model(&define.PendingNode{}).Where("node_id = ?", nodeId).
+		Update("status", define.PendingStatusRejected).Error
+}
+
+// Delete forgets the registration of nodeId.
+func Delete(nodeId string) error {
+	return vars.DB.Where("node_id = ?", nodeId).Delete(&define.PendingNode{}).Error
+}
@@ -0,0 +1,74 @@
+package sensors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+// ReadLmSensors reads every sensor from the output of `sensors -j`.
+func ReadLmSensors() ([]define.SensorStat, error) {
+	cmd := exec.Command("sensors", "-j")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("call sensors: %w", err)
+	}
+
+	return parseSensorsOutput(output)
+}
+
+func parseSensorsOutput(output []byte) ([]define.SensorStat, error) {
+	var result map[string]any
+	err := json.Unmarshal(output, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []define.SensorStat
+	for chipName, chipDataRaw := range result {
+		chipData, ok := chipDataRaw.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		for fieldName, fieldValueRaw := range chipData {
+			fieldValue, ok := fieldValueRaw.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			for key, value := range fieldValue {
+				match := inputRegex.FindStringSubmatch(key)
+				if match == nil {
+					continue
+				}
+				input, ok := value.(float64)
+				if !ok {
+					continue
+				}
+				// power meters report an average when they have no input
+				attr := match[1] + match[2]
+				if match[3] == "average" {
+					if _, ok := fieldValue[attr+"_input"]; ok {
+						continue
+					}
+				}
+				// thresholds share the prefix of the input, like temp1_crit
+				reading := newReading(chipName, fieldName, match[1])
+				reading.Value = input
+				reading.Min, _ = fieldValue[attr+"_min"].(float64)
+				reading.Max, _ = fieldValue[attr+"_max"].(float64)
+				reading.Crit, _ = fieldValue[attr+"_crit"].(float64)
+				readings = append(readings, reading)
+			}
+		}
+	}
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Name < readings[j].Name
+	})
+	return readings, nil
+}
@@ -1,60 +1,67 @@
 package sensors
 
 import (
-	"encoding/json"
-	"fmt"
-	"os/exec"
 	"regexp"
-)
 
-var tempRegex = regexp.MustCompile(`^temp\d+_input$`)
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
 
-func Get() (map[string]float64, error) {
-	cmd := exec.Command("sensors", "-j")
+// DefaultSysfsRoot is where the kernel exposes hwmon and thermal devices.
+const DefaultSysfsRoot = "/sys"
 
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("call sensors: %w", err)
-	}
+// inputRegex matches the input attributes of hwmon and lm-sensors, like
+// temp1_input, fan2_input, in0_input or power1_average.
+var inputRegex = regexp.MustCompile(`^(temp|fan|in|power)(\d+)_(input|average)$`)
 
-	return parseSensorsOutput(output)
+// sensorTypes maps attribute prefixes to sensor types.
+var sensorTypes = map[string]string{
+	"temp":  define.SensorTemperature,
+	"fan":   define.SensorFan,
+	"in":    define.SensorVoltage,
+	"power": define.SensorPower,
 }
 
-func parseSensorsOutput(output []byte) (map[string]float64, error) {
-	var result map[string]any
-	err := json.Unmarshal(output, &result)
-	if err != nil {
-		return nil, err
+// Get reads every sensor under the sysfs root, it falls back to lm-sensors
+// when sysfs has no sensor.
+func Get(sysfsRoot string) ([]define.SensorStat, error) {
+	readings, err := ReadSysfs(sysfsRoot)
+	if err == nil && len(readings) > 0 {
+		return readings, nil
 	}
+	return ReadLmSensors()
+}
 
-	temperatureMap := make(map[string]float64)
-
-SENSOR_LOOP:
-	for sensorName, sensorDataRaw := range result {
-		sensorData, ok := sensorDataRaw.(map[string]any)
-		if !ok {
-			continue SENSOR_LOOP
+// Temperatures returns the temperature readings as a map of sensor name to
+// celsius. A chip with a single temperature keeps the chip name as its key,
+// the key older agents reported, history and alert rules depend on it.
+func Temperatures(readings []define.SensorStat) map[string]float64 {
+	counts := make(map[string]int)
+	for _, r := range readings {
+		if r.Type == define.SensorTemperature {
+			counts[r.Chip]++
 		}
-
-		for _, fieldValueRaw := range sensorData {
-			fieldValue, ok := fieldValueRaw.(map[string]any)
-			if !ok {
-				continue
-			}
-
-			for tempKey, tempValue := range fieldValue {
-				if tempRegex.MatchString(tempKey) {
-					tempValueFloat, ok := tempValue.(float64)
-					if !ok {
-						continue
-					}
-
-					temperatureMap[sensorName] = tempValueFloat
-
-					continue SENSOR_LOOP
-				}
-			}
+	}
+	result := make(map[string]float64, len(readings))
+	for _, r := range readings {
+		if r.Type != define.SensorTemperature {
+			continue
+		}
+		if counts[r.Chip] == 1 {
+			result[r.Chip] = r.Value
+		} else {
+			result[r.Name] = r.Value
 		}
 	}
-	return temperatureMap, nil
+	return result
+}
+
+func newReading(chip, label, prefix string) define.SensorStat {
+	sensorType := sensorTypes[prefix]
+	return define.SensorStat{
+		Chip:  chip,
+		Name:  chip + "/" + label,
+		Label: label,
+		Type:  sensorType,
+		Unit:  define.SensorUnits[sensorType],
+	}
 }
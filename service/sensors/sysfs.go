@@ -0,0 +1,230 @@
+package sensors
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+// hwmonScales divides the raw hwmon values into the sensor units, sysfs
+// has millidegrees celsius, millivolts and microwatts.
+var hwmonScales = map[string]float64{
+	"temp":  1000,
+	"fan":   1,
+	"in":    1000,
+	"power": 1000000,
+}
+
+// ReadSysfs reads the sensors of class/hwmon and the temperatures of
+// class/thermal under root.
+func ReadSysfs(root string) ([]define.SensorStat, error) {
+	hwmon, err := readHwmon(filepath.Join(root, "class", "hwmon"))
+	if err != nil {
+		return nil, err
+	}
+	thermal, err := readThermal(filepath.Join(root, "class", "thermal"))
+	if err != nil {
+		return nil, err
+	}
+	readings := append(hwmon, thermal...)
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].Name < readings[j].Name
+	})
+	return readings, nil
+}
+
+func readHwmon(dir string) ([]define.SensorStat, error) {
+	devices, err := listDir(dir, "hwmon")
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []define.SensorStat
+	chips := make(map[string]struct{})
+	for _, device := range devices {
+		path := filepath.Join(dir, device)
+		// older kernels keep the attributes in the device directory
+		if _, err := os.Stat(filepath.Join(path, "name")); err != nil {
+			path = filepath.Join(path, "device")
+		}
+		chip := readString(filepath.Join(path, "name"))
+		if chip == "" {
+			chip = device
+		} else {
+			chip = chipName(path, chip)
+		}
+		// several chips of the same driver, like two nvme drives
+		if _, ok := chips[chip]; ok {
+			chip += "-" + device
+		}
+		chips[chip] = struct{}{}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			match := inputRegex.FindStringSubmatch(e.Name())
+			if match == nil {
+				continue
+			}
+			// power meters report an average when they have no input
+			attr := filepath.Join(path, match[1]+match[2])
+			if match[3] == "average" {
+				if _, err := os.Stat(attr + "_input"); err == nil {
+					continue
+				}
+			}
+			scale := hwmonScales[match[1]]
+			value, ok := readScaled(filepath.Join(path, e.Name()), scale)
+			if !ok {
+				continue
+			}
+			label := readString(attr + "_label")
+			if label == "" {
+				label = match[1] + match[2]
+			}
+			reading := newReading(chip, label, match[1])
+			reading.Value = value
+			reading.Min, _ = readScaled(attr+"_min", scale)
+			reading.Max, _ = readScaled(attr+"_max", scale)
+			reading.Crit, _ = readScaled(attr+"_crit", scale)
+			readings = append(readings, reading)
+		}
+	}
+	return readings, nil
+}
+
+func readThermal(dir string) ([]define.SensorStat, error) {
+	zones, err := listDir(dir, "thermal_zone")
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []define.SensorStat
+	for _, zone := range zones {
+		path := filepath.Join(dir, zone)
+		value, ok := readScaled(filepath.Join(path, "temp"), 1000)
+		if !ok {
+			continue
+		}
+		label := readString(filepath.Join(path, "type"))
+		if label == "" {
+			label = zone
+		}
+		reading := newReading(zone, label, "temp")
+		reading.Value = value
+		// trip points are numbered from 0 until one is missing
+		for i := 0; ; i++ {
+			prefix := filepath.Join(path, "trip_point_"+strconv.Itoa(i))
+			tripType := readString(prefix + "_type")
+			if tripType == "" {
+				break
+			}
+			temp, ok := readScaled(prefix+"_temp", 1000)
+			if !ok {
+				continue
+			}
+			switch tripType {
+			case "critical":
+				reading.Crit = temp
+			case "hot":
+				reading.Max = temp
+			}
+		}
+		readings = append(readings, reading)
+	}
+	return readings, nil
+}
+
+// chipName names a hwmon chip the way libsensors does, like
+// coretemp-isa-0000 or nvme-pci-0100, so readings from sysfs and from
+// lm-sensors share their names. Buses libsensors does not know keep the
+// bare name.
+func chipName(path, name string) string {
+	if filepath.Base(path) != "device" {
+		path = filepath.Join(path, "device")
+	}
+	device, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return name + "-virtual-0"
+	}
+	subsystem, err := filepath.EvalSymlinks(filepath.Join(device, "subsystem"))
+	if err != nil {
+		return name
+	}
+	dev := filepath.Base(device)
+	switch filepath.Base(subsystem) {
+	case "pci":
+		var domain, bus, slot, fn int
+		if _, err := fmt.Sscanf(dev, "%x:%x:%x.%x", &domain, &bus, &slot, &fn); err != nil {
+			return name
+		}
+		return fmt.Sprintf("%s-pci-%04x", name, domain<<16+bus<<8+slot<<3+fn)
+	case "platform", "of_platform":
+		// platform devices are numbered after a dot, like coretemp.0
+		addr := 0
+		if i := strings.LastIndexByte(dev, '.'); i >= 0 {
+			addr, _ = strconv.Atoi(dev[i+1:])
+		}
+		return fmt.Sprintf("%s-isa-%04x", name, addr)
+	case "i2c":
+		var bus, addr int
+		if _, err := fmt.Sscanf(dev, "%d-%x", &bus, &addr); err != nil {
+			return name
+		}
+		return fmt.Sprintf("%s-i2c-%d-%02x", name, bus, addr)
+	case "acpi":
+		addr := 0
+		if i := strings.LastIndexByte(dev, ':'); i >= 0 {
+			addr, _ = strconv.Atoi(dev[i+1:])
+		}
+		return fmt.Sprintf("%s-acpi-%x", name, addr)
+	}
+	return name
+}
+
+// listDir returns the entries of dir starting with prefix in natural order,
+// a missing dir has no entries.
+func listDir(dir, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ni, _ := strconv.Atoi(strings.TrimPrefix(names[i], prefix))
+		nj, _ := strconv.Atoi(strings.TrimPrefix(names[j], prefix))
+		return ni < nj
+	})
+	return names, nil
+}
+
+func readString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readScaled(path string, scale float64) (float64, bool) {
+	value, err := strconv.ParseFloat(readString(path), 64)
+	if err != nil {
+		return 0, false
+	}
+	return value / scale, true
+}
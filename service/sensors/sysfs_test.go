@@ -0,0 +1,94 @@
+package sensors
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/zjyl1994/cloudstatus/infra/define"
+)
+
+func TestReadSysfs(t *testing.T) {
+	readings, err := ReadSysfs(filepath.Join("testdata", "sys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []define.SensorStat{
+		{Chip: "board-virtual-0", Name: "board-virtual-0/fan1", Label: "fan1", Type: define.SensorFan, Unit: "RPM", Value: 1250},
+		{Chip: "board-virtual-0", Name: "board-virtual-0/in0", Label: "in0", Type: define.SensorVoltage, Unit: "V", Value: 1.8, Min: 1.7},
+		{Chip: "board-virtual-0", Name: "board-virtual-0/power1", Label: "power1", Type: define.SensorPower, Unit: "W", Value: 12.5},
+		{Chip: "coretemp-isa-0000", Name: "coretemp-isa-0000/Core 0", Label: "Core 0", Type: define.SensorTemperature, Unit: "°C", Value: 48.5},
+		{Chip: "coretemp-isa-0000", Name: "coretemp-isa-0000/Package id 0", Label: "Package id 0", Type: define.SensorTemperature, Unit: "°C", Value: 52, Max: 84, Crit: 100},
+		{Chip: "thermal_zone0", Name: "thermal_zone0/x86_pkg_temp", Label: "x86_pkg_temp", Type: define.SensorTemperature, Unit: "°C", Value: 45, Max: 95, Crit: 105},
+	}
+	if !reflect.DeepEqual(readings, want) {
+		t.Errorf("got  %+v\nwant %+v", readings, want)
+	}
+}
+
+func TestReadSysfsMissing(t *testing.T) {
+	readings, err := ReadSysfs(t.TempDir())
+	if err != nil || len(readings) != 0 {
+		t.Errorf("got %v, %v, want no readings", readings, err)
+	}
+}
+
+func TestChipName(t *testing.T) {
+	root := t.TempDir()
+	for _, bus := range []string{"pci", "platform", "i2c", "acpi", "usb"} {
+		if err := os.MkdirAll(filepath.Join(root, "bus", bus), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		bus, device, name, want string
+	}{
+		{"pci", "0000:01:00.0", "nvme", "nvme-pci-0100"},
+		{"pci", "0000:00:18.3", "k10temp", "k10temp-pci-00c3"},
+		{"platform", "coretemp.0", "coretemp", "coretemp-isa-0000"},
+		{"platform", "nct6775.656", "nct6798", "nct6798-isa-0290"},
+		{"i2c", "1-004c", "lm90", "lm90-i2c-1-4c"},
+		{"acpi", "ACPI000D:00", "power_meter", "power_meter-acpi-0"},
+		{"usb", "1-1", "corsair", "corsair"},
+		{"", "", "acpitz", "acpitz-virtual-0"},
+	}
+	for i, tt := range tests {
+		hwmon := filepath.Join(root, "hwmon", tt.name+"-"+tt.bus)
+		if err := os.MkdirAll(hwmon, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if tt.bus != "" {
+			device := filepath.Join(root, "devices", tt.bus, tt.device)
+			if err := os.MkdirAll(device, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(filepath.Join(root, "bus", tt.bus), filepath.Join(device, "subsystem")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(device, filepath.Join(hwmon, "device")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := chipName(hwmon, tt.name); got != tt.want {
+			t.Errorf("%d: chipName(%s) = %q, want %q", i, tt.device, got, tt.want)
+		}
+	}
+}
+
+func TestTemperatures(t *testing.T) {
+	readings := []define.SensorStat{
+		{Chip: "coretemp-isa-0000", Name: "coretemp-isa-0000/Core 0", Type: define.SensorTemperature, Value: 48.5},
+		{Chip: "coretemp-isa-0000", Name: "coretemp-isa-0000/Package id 0", Type: define.SensorTemperature, Value: 52},
+		{Chip: "nvme-pci-0100", Name: "nvme-pci-0100/Composite", Type: define.SensorTemperature, Value: 38.85},
+		{Chip: "nvme-pci-0100", Name: "nvme-pci-0100/fan1", Type: define.SensorFan, Value: 1250},
+	}
+	want := map[string]float64{
+		"coretemp-isa-0000/Core 0":       48.5,
+		"coretemp-isa-0000/Package id 0": 52,
+		"nvme-pci-0100":                  38.85,
+	}
+	if got := Temperatures(readings); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}